@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+const (
+	ROM_SIZE = 1 << 15
+	RAM_SIZE = 1 << 15
+)
+
+type CPU struct {
+	A, D, PC uint16
+
+	ROM [ROM_SIZE]uint16
+	RAM [RAM_SIZE]uint16
+
+	size   int
+	halted bool
+}
+
+func newCPU(code []uint16) *CPU {
+	cpu := &CPU{}
+	cpu.load(code)
+	return cpu
+}
+
+func (cpu *CPU) load(code []uint16) {
+	if len(code) > ROM_SIZE {
+		code = code[:ROM_SIZE]
+	}
+	copy(cpu.ROM[:], code)
+	cpu.size = len(code)
+}
+
+// loadRAM copies an initial RAM image starting at address 0
+func (cpu *CPU) loadRAM(ram []uint16) {
+	copy(cpu.RAM[:], ram)
+}
+
+func (cpu *CPU) reset() {
+	cpu.A, cpu.D, cpu.PC = 0, 0, 0
+	cpu.halted = false
+}
+
+func alu(x, y uint16, i uint16) (out uint16) {
+	if i&hack.ZX != 0 {
+		x = 0
+	}
+	if i&hack.NX != 0 {
+		x = ^x
+	}
+	if i&hack.ZY != 0 {
+		y = 0
+	}
+	if i&hack.NY != 0 {
+		y = ^y
+	}
+
+	if i&hack.F != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+
+	if i&hack.NO != 0 {
+		out = ^out
+	}
+
+	return
+}
+
+func isJump(out uint16, i uint16) bool {
+	var (
+		lt = int16(out) < 0
+		eq = out == 0
+		gt = int16(out) > 0
+	)
+
+	return (i&hack.JLT_MASK == hack.JLT_MASK && lt) ||
+		(i&hack.JEQ_MASK == hack.JEQ_MASK && eq) ||
+		(i&hack.JGT_MASK == hack.JGT_MASK && gt)
+}
+
+// step executes one instruction and reports whether the CPU halted,
+// either by running off the end of the program or by entering a loop
+// which jumps to itself without touching any register.
+func (cpu *CPU) step() bool {
+	if cpu.halted {
+		return true
+	}
+
+	if int(cpu.PC) >= cpu.size {
+		cpu.halted = true
+		return true
+	}
+
+	pc := cpu.PC
+	i := cpu.ROM[pc]
+
+	if i&hack.A_INST_MASK == 0 {
+		cpu.A = i
+		cpu.PC++
+		return false
+	}
+
+	addr := cpu.A & (RAM_SIZE - 1)
+
+	y := cpu.A
+	if i&hack.A_COMP != 0 {
+		y = cpu.RAM[addr]
+	}
+
+	out := alu(cpu.D, y, i)
+	jump := isJump(out, i)
+	target := cpu.A
+
+	if i&hack.M_DEST != 0 {
+		cpu.RAM[addr] = out
+	}
+	if i&hack.D_DEST != 0 {
+		cpu.D = out
+	}
+	if i&hack.A_DEST != 0 {
+		cpu.A = out
+	}
+
+	if !jump {
+		cpu.PC++
+		return false
+	}
+
+	cpu.PC = target
+
+	if i&hack.DEST_MASK == 0 &&
+		(target == pc || (target+1 == pc && cpu.ROM[target] == target)) {
+		cpu.halted = true
+	}
+
+	return cpu.halted
+}
+
+// run executes at most cycles instructions and returns how many of them
+// were executed and whether the program halted.
+func (cpu *CPU) run(cycles int) (n int, halted bool) {
+	for n < cycles {
+		if cpu.halted || int(cpu.PC) >= cpu.size {
+			cpu.halted = true
+			return n, true
+		}
+		n++
+		if cpu.step() {
+			return n, true
+		}
+	}
+
+	return n, cpu.halted
+}
+
+type memRange struct {
+	from, to uint16
+}
+
+func parseMemAddr(str string) (uint16, error) {
+	if symbol, ok := hack.DefaultSymbols()[str]; ok && str != hack.VAR {
+		return symbol.Addr, nil
+	}
+
+	res, err := strconv.ParseUint(str, 0, 16)
+
+	if err != nil || res >= RAM_SIZE {
+		return 0, fmt.Errorf("bad RAM address \"%s\"", str)
+	}
+
+	return uint16(res), nil
+}
+
+// parseMemRanges parses comma separated RAM ranges like "0-15,256,SCREEN"
+func parseMemRanges(str string) (ranges []memRange, err error) {
+	for _, part := range strings.Split(str, ",") {
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+
+		var r memRange
+
+		if r.from, err = parseMemAddr(bounds[0]); err != nil {
+			return nil, err
+		}
+
+		r.to = r.from
+
+		if len(bounds) > 1 {
+			if r.to, err = parseMemAddr(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		if r.to < r.from {
+			return nil, fmt.Errorf("bad RAM range \"%s\"", part)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return
+}
+
+func (cpu *CPU) dumpRAM(w io.Writer, ranges []memRange) {
+	for _, r := range ranges {
+		for addr := int(r.from); addr <= int(r.to); addr++ {
+			fmt.Fprintf(w, "RAM[%d] = %d\n", addr, int16(cpu.RAM[addr]))
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+func mustCompile(t *testing.T, src string) []uint16 {
+	code, err := hack.New("test.asm").Assemble(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func runSource(t *testing.T, src string, cycles int) (*CPU, int, bool) {
+	cpu := newCPU(mustCompile(t, src))
+	n, halted := cpu.run(cycles)
+	return cpu, n, halted
+}
+
+func TestALU(t *testing.T) {
+	examples := map[string]int16{
+		"0":   0,
+		"1":   1,
+		"-1":  -1,
+		"D":   7,
+		"A":   3,
+		"!D":  ^7,
+		"!A":  ^3,
+		"-D":  -7,
+		"-A":  -3,
+		"D+1": 8,
+		"A+1": 4,
+		"D-1": 6,
+		"A-1": 2,
+		"D+A": 10,
+		"D-A": 4,
+		"A-D": -4,
+		"D&A": 3,
+		"D|A": 7,
+	}
+
+	for comp, expected := range examples {
+		mask := mustCompile(t, comp)[0] & hack.COMP_MASK
+		res := int16(alu(7, 3, mask))
+		if res != expected {
+			t.Errorf("%s should eq %d, but have %d", comp, expected, res)
+		}
+	}
+}
+
+func TestRunAdd(t *testing.T) {
+	cpu, _, halted := runSource(t, `
+		@2
+		D=A
+		@3
+		D=D+A
+		@0
+		M=D
+	(END)
+		@END
+		0;JMP
+	`, 100)
+
+	switch {
+	case !halted:
+		t.Fatal("Program should halt")
+	case cpu.RAM[0] != 5:
+		t.Fatalf("RAM[0] should eq 5, but have %d", cpu.RAM[0])
+	case cpu.PC != 6:
+		t.Fatalf("PC should stay at the halt loop, but have %d", cpu.PC)
+	}
+}
+
+func TestRunCallRet(t *testing.T) {
+	cpu, _, halted := runSource(t, `
+		@256
+		D=A
+		MOV SP,D
+		@5
+		D=A
+		CALL double
+		MOV R1,D
+		HALT
+	(double)
+		MOV R13,D
+		D=D+M
+		RET
+	`, 100)
+
+	switch {
+	case !halted:
+		t.Fatal("Program should halt")
+	case cpu.RAM[1] != 10:
+		t.Fatalf("RAM[1] should eq 10, but have %d", cpu.RAM[1])
+	case cpu.RAM[0] != 256:
+		t.Fatalf("SP should be back at 256, but have %d", cpu.RAM[0])
+	}
+}
+
+func TestRunLoop(t *testing.T) {
+	cpu, _, halted := runSource(t, `
+		@10
+		D=A
+		@i
+		M=D
+		@sum
+		M=0
+	(LOOP)
+		@i
+		D=M
+		@END
+		D;JEQ
+		@sum
+		M=D+M
+		@i
+		M=M-1
+		@LOOP
+		0;JMP
+	(END)
+		@END
+		0;JMP
+	`, 1000)
+
+	if !halted {
+		t.Fatal("Program should halt")
+	}
+
+	if cpu.RAM[17] != 55 {
+		t.Fatalf("sum should eq 55, but have %d", cpu.RAM[17])
+	}
+}
+
+func TestRunCycles(t *testing.T) {
+	_, n, halted := runSource(t, "(LOOP)\n@i\nM=M+1\n@LOOP\n0;JMP", 10)
+
+	switch {
+	case halted:
+		t.Fatal("Program should not halt")
+	case n != 10:
+		t.Fatalf("Expected to run 10 cycles, but have %d", n)
+	}
+}
+
+func TestRunOffTheEnd(t *testing.T) {
+	_, n, halted := runSource(t, "@1\nD=A", 10)
+
+	if !halted || n != 2 {
+		t.Fatalf("Program should halt after 2 cycles, have %d %v", n, halted)
+	}
+}
+
+func TestJumpUsesOldA(t *testing.T) {
+	cpu, _, _ := runSource(t, "@4\nA=A+1;JMP\n@100\n0;JMP\nD=1\n", 3)
+
+	if cpu.D != 1 {
+		t.Fatalf("Should jump to the old value of A, D is %d", cpu.D)
+	}
+}
+
+func TestReadCode(t *testing.T) {
+	code := mustCompile(t, "@100\nD=A\n@SCREEN\nM=D")
+	res, err := readCode(newCodeReader(code))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != len(code) {
+		t.Fatalf("Expected %d instructions, have %d", len(code), len(res))
+	}
+
+	for i := range code {
+		if res[i] != code[i] {
+			t.Errorf("Instruction %d should eq %016b, have %016b", i, code[i], res[i])
+		}
+	}
+
+	if _, err := readCode(strings.NewReader("0101")); err == nil {
+		t.Error("Short lines should not be accepted")
+	}
+}
+
+func TestParseMemRanges(t *testing.T) {
+	ranges, err := parseMemRanges("0-2,SCREEN,KBD")
+
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case len(ranges) != 3:
+		t.Fatalf("Expected 3 ranges, have %d", len(ranges))
+	case ranges[0] != memRange{0, 2}:
+		t.Errorf("Wrong first range: %v", ranges[0])
+	case ranges[1] != memRange{0x4000, 0x4000}:
+		t.Errorf("Wrong second range: %v", ranges[1])
+	}
+
+	if _, err := parseMemRanges("5-2"); err == nil {
+		t.Error("Reversed range should not be accepted")
+	}
+}
+
+func TestDumpRAM(t *testing.T) {
+	cpu := newCPU(nil)
+	cpu.RAM[1] = 0xffff
+
+	buf := &bytes.Buffer{}
+	cpu.dumpRAM(buf, []memRange{{0, 1}})
+
+	if buf.String() != "RAM[0] = 0\nRAM[1] = -1\n" {
+		t.Errorf("Unexpected dump: %q", buf.String())
+	}
+}
+
+func TestLoadRAM(t *testing.T) {
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(".var x\n.data x 40 2\n@x\nD=M\nA=A+1\nD=D+M\n@R0\nM=D"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := newCPU(prog.Code)
+	cpu.loadRAM(prog.RAM())
+	cpu.run(100)
+
+	if cpu.RAM[0] != 42 {
+		t.Errorf("Expected 42 in RAM[0], have %d", cpu.RAM[0])
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+	"github.com/mluts/learning-go/hack-assembler/lsp"
+)
+
+// DAP_THREAD is the only thread of a program
+const DAP_THREAD = 1
+
+// References of the variable scopes
+const (
+	DAP_REGISTERS = iota + 1
+	DAP_VARIABLES
+	DAP_POINTERS
+)
+
+// DAP_POINTER_NAMES are the predefined symbols shown with the registers
+// of the VM
+var DAP_POINTER_NAMES = []string{"SP", "LCL", "ARG", "THIS", "THAT"}
+
+// DAP_WHILE_RUNNING are the requests answered while the program runs, the
+// others would change the debugger under it
+var DAP_WHILE_RUNNING = map[string]bool{
+	"threads":    true,
+	"stackTrace": true,
+	"scopes":     true,
+	"variables":  true,
+	"evaluate":   true,
+	"pause":      true,
+	"disconnect": true,
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// dapError fails a request with its message
+type dapError string
+
+func (e dapError) Error() string {
+	return string(e)
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapBreakpoint struct {
+	ID       int    `json:"id,omitempty"`
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Source *dapSource `json:"source,omitempty"`
+	Line   int        `json:"line"`
+	Column int        `json:"column"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	EvaluateName       string `json:"evaluateName,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type dapStopped struct {
+	Reason            string `json:"reason"`
+	Description       string `json:"description,omitempty"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+	HitBreakpointIDs  []int  `json:"hitBreakpointIds,omitempty"`
+}
+
+type dapLaunchArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	// Cycles limits the instructions of one continue, 0 for no limit
+	Cycles int `json:"cycles"`
+}
+
+type dapSetBreakpointsArguments struct {
+	Source      dapSource `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+// dapServer debugs a program for a client of the Debug Adapter Protocol,
+// requests are read by a goroutine so they're answered while it runs
+type dapServer struct {
+	w        io.Writer
+	seq      int
+	requests chan *dapRequest
+	done     chan bool
+	err      error
+
+	d *debugger
+	// breakpoints holds the IDs of the breakpoints of every source file
+	breakpoints map[string][]int
+	stopOnEntry bool
+	// after runs once the response of a request is sent
+	after   func() error
+	running bool
+	paused  bool
+	quit    bool
+	// fail keeps an error of a request answered while the program runs
+	fail error
+}
+
+// serveDAP reads requests from r and writes responses and events to w
+// until the client disconnects or closes r
+func serveDAP(r io.Reader, w io.Writer) error {
+	s := &dapServer{
+		w:           w,
+		requests:    make(chan *dapRequest),
+		done:        make(chan bool),
+		breakpoints: map[string][]int{},
+	}
+
+	defer close(s.done)
+	go s.read(bufio.NewReader(r))
+
+	for req := range s.requests {
+		if err := s.handle(req); err != nil || s.quit {
+			return err
+		}
+	}
+
+	if s.err == io.EOF {
+		return nil
+	}
+
+	return s.err
+}
+
+// read parses the messages framed by a Content-Length header
+func (s *dapServer) read(r *bufio.Reader) {
+	defer close(s.requests)
+
+	for {
+		body, err := lsp.ReadMessage(r)
+
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		req := &dapRequest{}
+
+		if err = json.Unmarshal(body, req); err != nil {
+			s.err = err
+			return
+		}
+
+		select {
+		case s.requests <- req:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// send writes a response or an event, numbering it
+func (s *dapServer) send(msg interface{}) error {
+	s.seq++
+
+	switch m := msg.(type) {
+	case *dapResponse:
+		m.Seq = s.seq
+	case *dapEvent:
+		m.Seq = s.seq
+	}
+
+	body, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *dapServer) event(name string, body interface{}) error {
+	return s.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// handle answers a request, failures of the request are reported to the
+// client and other errors end the session
+func (s *dapServer) handle(req *dapRequest) error {
+	body, err := s.call(req.Command, req.Arguments)
+	resp := &dapResponse{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body}
+
+	if e, ok := err.(dapError); ok {
+		resp.Success, resp.Message = false, e.Error()
+	} else if err != nil {
+		return err
+	}
+
+	if err = s.send(resp); err != nil {
+		return err
+	}
+
+	if after := s.after; after != nil {
+		s.after = nil
+		return after()
+	}
+
+	return nil
+}
+
+// call runs a request and returns the body of its response
+func (s *dapServer) call(command string, args json.RawMessage) (interface{}, error) {
+	if s.running && !DAP_WHILE_RUNNING[command] {
+		return nil, dapError("the program is running")
+	}
+
+	switch command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+		}, nil
+
+	case "launch":
+		var a dapLaunchArguments
+
+		if err := json.Unmarshal(args, &a); err != nil || a.Program == "" {
+			return nil, dapError("expected the program to launch")
+		}
+
+		path, err := filepath.Abs(a.Program)
+
+		if err == nil {
+			s.d, err = loadDebugger(path, "", "", "", "", "")
+		}
+
+		if err != nil {
+			return nil, dapError(err.Error())
+		}
+
+		s.d.cycles = math.MaxInt32
+
+		if a.Cycles > 0 {
+			s.d.cycles = a.Cycles
+		}
+
+		s.stopOnEntry = a.StopOnEntry
+		s.after = func() error { return s.event("initialized", nil) }
+		return nil, nil
+
+	case "setBreakpoints":
+		var a dapSetBreakpointsArguments
+
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, dapError(err.Error())
+		}
+
+		return map[string][]dapBreakpoint{"breakpoints": s.setBreakpoints(a)}, nil
+
+	case "configurationDone":
+		if s.d == nil {
+			return nil, dapError("no program launched")
+		}
+
+		s.after = func() error {
+			if s.stopOnEntry {
+				return s.event("stopped", dapStopped{Reason: "entry", ThreadID: DAP_THREAD, AllThreadsStopped: true})
+			}
+			return s.run(func() stop { return s.d.resume(func() bool { return false }) })
+		}
+		return nil, nil
+
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": DAP_THREAD, "name": "main"}},
+		}, nil
+
+	case "stackTrace":
+		frames := []dapStackFrame{}
+
+		if s.d != nil {
+			frames = append(frames, s.frame())
+		}
+
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		return map[string][]dapScope{"scopes": {
+			{"Registers", DAP_REGISTERS, false},
+			{"Variables", DAP_VARIABLES, false},
+			{"Pointers", DAP_POINTERS, false},
+		}}, nil
+
+	case "variables":
+		var a struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, dapError(err.Error())
+		}
+
+		return map[string][]dapVariable{"variables": s.variables(a.VariablesReference)}, nil
+
+	case "evaluate":
+		var a struct {
+			Expression string `json:"expression"`
+		}
+
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, dapError(err.Error())
+		}
+
+		result, err := s.evaluate(strings.TrimSpace(a.Expression))
+
+		if err != nil {
+			return nil, dapError(err.Error())
+		}
+
+		return map[string]interface{}{"result": result, "variablesReference": 0}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		if s.d == nil {
+			return nil, dapError("no program launched")
+		}
+
+		d := s.d
+		exec := map[string]func() stop{
+			"continue": func() stop { return d.resume(func() bool { return false }) },
+			"next":     d.next,
+			"stepIn":   d.stepLine,
+			"stepOut":  d.finish,
+		}[command]
+
+		s.after = func() error { return s.run(exec) }
+
+		if command == "continue" {
+			return map[string]bool{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+
+	case "pause":
+		s.paused = s.running
+		return nil, nil
+
+	case "disconnect":
+		s.quit = true
+		return nil, nil
+	}
+
+	return nil, dapError("unknown command " + command)
+}
+
+// run executes the program answering requests until exec returns, then
+// reports why it stopped
+func (s *dapServer) run(exec func() stop) error {
+	s.running, s.paused = true, false
+	s.d.interrupt = s.poll
+	stopped := exec()
+	s.running, s.d.interrupt = false, nil
+
+	if s.fail != nil || s.quit {
+		return s.fail
+	}
+
+	return s.report(stopped)
+}
+
+// poll answers the requests arriving while the program runs, reporting
+// whether one of them pauses it or ends the session
+func (s *dapServer) poll() bool {
+	for {
+		select {
+		case req, ok := <-s.requests:
+			if !ok {
+				s.quit = true
+				return true
+			}
+
+			if s.fail = s.handle(req); s.fail != nil || s.quit || s.paused {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// report sends the events telling why the program stopped
+func (s *dapServer) report(stopped stop) error {
+	event := dapStopped{Reason: "step", ThreadID: DAP_THREAD, AllThreadsStopped: true}
+
+	switch stopped.Reason {
+	case STOP_HALT:
+		if err := s.event("exited", map[string]int{"exitCode": 0}); err != nil {
+			return err
+		}
+		return s.event("terminated", nil)
+	case STOP_BREAKPOINT:
+		event.Reason, event.HitBreakpointIDs = "breakpoint", []int{stopped.ID}
+	case STOP_WATCHPOINT:
+		event.Reason = "data breakpoint"
+	case STOP_INTERRUPT:
+		event.Reason = "pause"
+	case STOP_LIMIT:
+		event.Reason, event.Description = "pause", fmt.Sprintf("Stopped after %d cycles", stopped.Cycles)
+	}
+
+	return s.event("stopped", event)
+}
+
+// setBreakpoints replaces the breakpoints of a source file, lines without
+// code get the breakpoint at the next line with code
+func (s *dapServer) setBreakpoints(a dapSetBreakpointsArguments) []dapBreakpoint {
+	path := a.Source.Path
+
+	if s.d != nil {
+		for _, id := range s.breakpoints[path] {
+			s.d.remove(id)
+		}
+	}
+
+	s.breakpoints[path] = nil
+	breakpoints := []dapBreakpoint{}
+
+	for _, b := range a.Breakpoints {
+		if s.d == nil {
+			breakpoints = append(breakpoints, dapBreakpoint{Line: b.Line, Message: "no program launched"})
+			continue
+		}
+
+		addr, line, ok := s.d.codeLine(path, b.Line)
+
+		if !ok {
+			breakpoints = append(breakpoints, dapBreakpoint{Line: b.Line, Message: "no code at this line"})
+			continue
+		}
+
+		bp := s.d.breakAt(addr, false)
+		s.breakpoints[path] = append(s.breakpoints[path], bp.ID)
+		breakpoints = append(breakpoints, dapBreakpoint{ID: bp.ID, Verified: true, Line: line})
+	}
+
+	return breakpoints
+}
+
+// frame describes the only stack frame by the label and source line of PC
+func (s *dapServer) frame() dapStackFrame {
+	pc := s.d.cpu.PC
+	frame := dapStackFrame{ID: 1, Name: s.d.romName(pc), Column: 1}
+
+	if frame.Name == "" {
+		frame.Name = fmt.Sprintf("%d", pc)
+	}
+
+	if int(pc) >= s.d.cpu.size {
+		frame.Name = "end of program"
+	}
+
+	if line, ok := s.d.source[pc]; ok && line.file != "" {
+		frame.Source = &dapSource{filepath.Base(line.file), line.file}
+		frame.Line = line.num
+	}
+
+	return frame
+}
+
+// variables lists the registers, the variables of the symbol table by
+// address or the pointers of the VM
+func (s *dapServer) variables(ref int) []dapVariable {
+	vars := []dapVariable{}
+
+	if s.d == nil {
+		return vars
+	}
+
+	d := s.d
+
+	switch ref {
+	case DAP_REGISTERS:
+		for _, reg := range []string{"A", "D", "PC"} {
+			vars = append(vars, dapVariable{Name: reg, Value: d.registerValue(reg), EvaluateName: reg})
+		}
+
+	case DAP_VARIABLES:
+		var names []string
+
+		for name, symbol := range d.symbols {
+			if symbol.Kind == hack.S_VARIABLE {
+				names = append(names, name)
+			}
+		}
+
+		sort.Slice(names, func(i, j int) bool {
+			a, b := d.symbols[names[i]].Addr, d.symbols[names[j]].Addr
+			return a < b || a == b && names[i] < names[j]
+		})
+
+		for _, name := range names {
+			vars = append(vars, d.ramVariable(name, d.symbols[name].Addr))
+		}
+
+	case DAP_POINTERS:
+		for _, name := range DAP_POINTER_NAMES {
+			if symbol, ok := d.symbols[name]; ok {
+				vars = append(vars, d.ramVariable(name, symbol.Addr))
+			}
+		}
+	}
+
+	return vars
+}
+
+func (d *debugger) ramVariable(name string, addr uint16) dapVariable {
+	return dapVariable{Name: name, Value: fmt.Sprintf("%d", int16(d.cpu.RAM[addr])), EvaluateName: name}
+}
+
+// evaluate returns the value of a register or a RAM word, written as a
+// symbol, an address or RAM[ADDR]
+func (s *dapServer) evaluate(expr string) (string, error) {
+	if s.d == nil {
+		return "", fmt.Errorf("no program launched")
+	}
+
+	if reg := strings.ToUpper(expr); reg == "A" || reg == "D" || reg == "PC" {
+		return s.d.registerValue(reg), nil
+	}
+
+	addr, err := s.d.parseRAMAddr(strings.TrimSuffix(strings.TrimPrefix(expr, "RAM["), "]"))
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d", int16(s.d.cpu.RAM[addr])), nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dapClient is a scripted client of a DAP server, bodies of responses and
+// events are compared as JSON with the directory of the program as DIR
+type dapClient struct {
+	t    *testing.T
+	dir  string
+	w    io.Writer
+	r    *bufio.Reader
+	seq  int
+	done chan error
+}
+
+type dapMessage struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// startDAP saves src as test.asm in a temporary directory and serves it
+func startDAP(t *testing.T, src string) (*dapClient, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.asm")
+
+	if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, w := io.Pipe()
+	r, responses := io.Pipe()
+	c := &dapClient{t: t, dir: dir, w: w, r: bufio.NewReader(r), done: make(chan error, 1)}
+
+	go func() {
+		err := serveDAP(requests, responses)
+		responses.Close()
+		c.done <- err
+	}()
+
+	t.Cleanup(func() { w.Close() })
+	return c, path
+}
+
+func (c *dapClient) message() dapMessage {
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	length, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, length)
+
+	if _, err = io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var msg dapMessage
+
+	if err = json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+
+	msg.Body = json.RawMessage(strings.Replace(string(msg.Body), c.dir, "DIR", -1))
+	return msg
+}
+
+// request sends a request and returns its response, skipping events
+func (c *dapClient) request(command string, args interface{}) dapMessage {
+	c.seq++
+	body, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	for {
+		if msg := c.message(); msg.Type == "response" && msg.RequestSeq == c.seq {
+			return msg
+		}
+	}
+}
+
+// expect sends a request and checks the body of its response
+func (c *dapClient) expect(command string, args interface{}, expected string) {
+	msg := c.request(command, args)
+
+	if !msg.Success {
+		c.t.Errorf("Expected %s to succeed, have %s", command, msg.Message)
+	} else if string(msg.Body) != expected {
+		c.t.Errorf("Expected %s to return %s, have %s", command, expected, msg.Body)
+	}
+}
+
+// await checks the body of the next event, which has to be expected
+func (c *dapClient) await(event, expected string) {
+	msg := c.message()
+
+	if msg.Type != "event" || msg.Event != event {
+		c.t.Errorf("Expected %s event, have %s %s", event, msg.Event, msg.Type)
+	} else if expected != "" && string(msg.Body) != expected {
+		c.t.Errorf("Expected %s event %s, have %s", event, expected, msg.Body)
+	}
+}
+
+func TestDAPSession(t *testing.T) {
+	c, path := startDAP(t, DEBUG_SRC)
+	source := map[string]string{"path": path}
+	lines := func(lines ...int) interface{} {
+		breakpoints := []map[string]int{}
+
+		for _, line := range lines {
+			breakpoints = append(breakpoints, map[string]int{"line": line})
+		}
+
+		return map[string]interface{}{"source": source, "breakpoints": breakpoints}
+	}
+
+	stopped := func(reason string) string {
+		return fmt.Sprintf(`{"reason":"%s","threadId":1,"allThreadsStopped":true}`, reason)
+	}
+
+	frame := func(name string, line int) string {
+		return fmt.Sprintf(`{"stackFrames":[{"id":1,"name":"%s","source":{"name":"test.asm","path":"DIR/test.asm"},"line":%d,"column":1}],"totalFrames":1}`, name, line)
+	}
+
+	c.request("initialize", map[string]string{"adapterID": "hack"})
+	c.expect("launch", map[string]string{"program": path}, "")
+	c.await("initialized", "")
+
+	c.expect("setBreakpoints", lines(10, 16, 100), `{"breakpoints":[`+
+		`{"id":1,"verified":true,"line":11},`+
+		`{"id":2,"verified":true,"line":16},`+
+		`{"verified":false,"line":100,"message":"no code at this line"}]}`)
+
+	c.expect("configurationDone", nil, "")
+	c.await("stopped", `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true,"hitBreakpointIds":[1]}`)
+	c.expect("threads", nil, `{"threads":[{"id":1,"name":"main"}]}`)
+	c.expect("stackTrace", map[string]int{"threadId": 1}, frame("LOOP", 11))
+
+	c.expect("stepIn", map[string]int{"threadId": 1}, "")
+	c.await("stopped", stopped("step"))
+	c.expect("stackTrace", map[string]int{"threadId": 1}, frame("DEC", 16))
+	c.expect("scopes", map[string]int{"frameId": 1}, `{"scopes":[`+
+		`{"name":"Registers","variablesReference":1,"expensive":false},`+
+		`{"name":"Variables","variablesReference":2,"expensive":false},`+
+		`{"name":"Pointers","variablesReference":3,"expensive":false}]}`)
+	c.expect("variables", map[string]int{"variablesReference": 1}, `{"variables":[`+
+		`{"name":"A","value":"24","evaluateName":"A","variablesReference":0},`+
+		`{"name":"D","value":"3","evaluateName":"D","variablesReference":0},`+
+		`{"name":"PC","value":"24 \u003cDEC\u003e","evaluateName":"PC","variablesReference":0}]}`)
+
+	c.expect("next", map[string]int{"threadId": 1}, "")
+	c.await("stopped", stopped("step"))
+	c.expect("stackTrace", map[string]int{"threadId": 1}, frame("DEC+1", 17))
+
+	c.expect("stepOut", map[string]int{"threadId": 1}, "")
+	c.await("stopped", stopped("step"))
+	c.expect("stackTrace", map[string]int{"threadId": 1}, frame("LOOP+12", 12))
+	c.expect("variables", map[string]int{"variablesReference": 2}, `{"variables":[`+
+		`{"name":"i","value":"2","evaluateName":"i","variablesReference":0}]}`)
+	c.expect("variables", map[string]int{"variablesReference": 3}, `{"variables":[`+
+		`{"name":"SP","value":"256","evaluateName":"SP","variablesReference":0},`+
+		`{"name":"LCL","value":"0","evaluateName":"LCL","variablesReference":0},`+
+		`{"name":"ARG","value":"0","evaluateName":"ARG","variablesReference":0},`+
+		`{"name":"THIS","value":"0","evaluateName":"THIS","variablesReference":0},`+
+		`{"name":"THAT","value":"0","evaluateName":"THAT","variablesReference":0}]}`)
+
+	c.expect("evaluate", map[string]string{"expression": "i"}, `{"result":"2","variablesReference":0}`)
+	c.expect("evaluate", map[string]string{"expression": "RAM[0]"}, `{"result":"256","variablesReference":0}`)
+
+	if msg := c.request("evaluate", map[string]string{"expression": "NOPE"}); msg.Success || msg.Message != `bad RAM address "NOPE"` {
+		t.Errorf("Expected evaluate to fail, have %v %s", msg.Success, msg.Message)
+	}
+
+	c.expect("continue", map[string]int{"threadId": 1}, `{"allThreadsContinued":true}`)
+	c.await("stopped", `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true,"hitBreakpointIds":[1]}`)
+
+	c.expect("setBreakpoints", lines(), `{"breakpoints":[]}`)
+	c.expect("continue", map[string]int{"threadId": 1}, `{"allThreadsContinued":true}`)
+	c.await("exited", `{"exitCode":0}`)
+	c.await("terminated", "")
+
+	c.expect("disconnect", nil, "")
+
+	if err := <-c.done; err != nil {
+		t.Errorf("Expected the session to end, have %v", err)
+	}
+}
+
+func TestDAPPause(t *testing.T) {
+	c, path := startDAP(t, ".var i\n(LOOP)\n@i\nM=M+1\n@LOOP\n0;JMP\n")
+
+	if msg := c.request("launch", map[string]string{"program": path + ".missing"}); msg.Success {
+		t.Errorf("Expected launching a missing program to fail")
+	}
+
+	c.expect("launch", map[string]string{"program": path}, "")
+	c.await("initialized", "")
+	c.expect("configurationDone", nil, "")
+
+	for _, command := range []string{"next", "configurationDone", "launch", "setBreakpoints"} {
+		if msg := c.request(command, map[string]string{"program": path}); msg.Success || msg.Message != "the program is running" {
+			t.Errorf("Expected %s to fail while running, have %v %s", command, msg.Success, msg.Message)
+		}
+	}
+
+	c.expect("threads", nil, `{"threads":[{"id":1,"name":"main"}]}`)
+
+	c.expect("pause", map[string]int{"threadId": 1}, "")
+	c.await("stopped", `{"reason":"pause","threadId":1,"allThreadsStopped":true}`)
+	c.expect("disconnect", nil, "")
+
+	if err := <-c.done; err != nil {
+		t.Errorf("Expected the session to end, have %v", err)
+	}
+}
+
+func TestDAPBadContentLength(t *testing.T) {
+	expected := `invalid Content-Length "-1"`
+
+	if err := serveDAP(strings.NewReader("Content-Length: -1\r\n\r\n"), ioutil.Discard); err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, have %v", expected, err)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+const (
+	STOP_STEP = iota
+	STOP_BREAKPOINT
+	STOP_WATCHPOINT
+	STOP_HALT
+	STOP_LIMIT
+	STOP_INTERRUPT
+)
+
+// INTERRUPT_CYCLES is how often a running program polls for an interrupt
+const INTERRUPT_CYCLES = 1024
+
+// stop tells why execution stopped, Reason is one of the STOP_
+// constants. For watchpoints Addr is the RAM address which changed from
+// Old to New.
+type stop struct {
+	Reason   int
+	ID       int
+	Addr     uint16
+	Old, New uint16
+	Cycles   int
+}
+
+// breakpoint stops execution at a ROM address, or when the RAM word at
+// the address changes for watchpoints. Breakpoints are kept by ID.
+type breakpoint struct {
+	ID    int
+	Addr  uint16
+	Watch bool
+}
+
+// debugger runs a program on the CPU under control of breakpoints and
+// watchpoints, naming addresses by the symbol table
+type debugger struct {
+	cpu *CPU
+	ram []uint16
+
+	symbols hack.SymbolTable
+	// source maps ROM addresses to the lines they come from
+	source map[uint16]sourceLine
+	files  map[string][]string
+
+	breakpoints []breakpoint
+	nextID      int
+	// cycles limits the instructions executed by one resume
+	cycles int
+	// interrupt stops execution when it reports true, nil when execution
+	// can't be interrupted
+	interrupt func() bool
+}
+
+func newDebugger(code, ram []uint16, symbols hack.SymbolTable, source map[uint16]sourceLine) *debugger {
+	d := &debugger{
+		cpu:     newCPU(code),
+		ram:     ram,
+		symbols: symbols,
+		source:  source,
+		files:   map[string][]string{},
+		nextID:  1,
+		cycles:  1000000,
+	}
+
+	if d.symbols == nil {
+		d.symbols = hack.DefaultSymbols()
+	}
+
+	if d.source == nil {
+		d.source = map[uint16]sourceLine{}
+	}
+
+	d.cpu.loadRAM(ram)
+	return d
+}
+
+// programSource maps the instructions of prog to the lines they
+// originate from
+func programSource(prog *hack.Program) map[uint16]sourceLine {
+	source := map[uint16]sourceLine{}
+
+	for _, line := range prog.Lines {
+		file, num := line.Origin()
+		source[line.Addr] = sourceLine{file, num, strings.TrimSpace(strings.SplitN(line.Src, hack.COMMENT, 2)[0])}
+	}
+
+	return source
+}
+
+// reset restarts the program with the initial RAM
+func (d *debugger) reset() {
+	d.cpu.reset()
+	d.cpu.RAM = [RAM_SIZE]uint16{}
+	d.cpu.loadRAM(d.ram)
+}
+
+// romName returns the label at addr, or the closest label before it with
+// an offset like LOOP+2. Labels generated for macros, pseudo-instructions
+// and anonymous labels are used only when there's no other.
+func (d *debugger) romName(addr uint16) string {
+	var best [2]string
+	var bestAddr [2]int
+
+	for name, symbol := range d.symbols {
+		if symbol.Kind != hack.S_LABEL || symbol.Addr > addr {
+			continue
+		}
+
+		k := 0
+
+		if strings.Contains(name, "$") {
+			k = 1
+		}
+
+		if best[k] == "" || int(symbol.Addr) > bestAddr[k] || int(symbol.Addr) == bestAddr[k] && name < best[k] {
+			best[k], bestAddr[k] = name, int(symbol.Addr)
+		}
+	}
+
+	for k := range best {
+		switch {
+		case best[k] == "":
+			continue
+		case uint16(bestAddr[k]) == addr:
+			return best[k]
+		default:
+			return fmt.Sprintf("%s+%d", best[k], int(addr)-bestAddr[k])
+		}
+	}
+
+	return ""
+}
+
+// ramName returns the symbol naming the RAM address: a variable, else a
+// predefined symbol preferring SP, LCL, ARG, THIS and THAT to R0-R4
+func (d *debugger) ramName(addr uint16) string {
+	rank := func(name string, symbol hack.Symbol) int {
+		switch {
+		case symbol.Kind == hack.S_VARIABLE:
+			return 3
+		case symbol.Kind != hack.S_PREDEFINED || name == hack.VAR:
+			return 0
+		case strings.HasPrefix(name, "R") && len(name) <= 3:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	best, bestRank := "", 0
+
+	for name, symbol := range d.symbols {
+		if symbol.Addr != addr {
+			continue
+		}
+
+		if r := rank(name, symbol); r > bestRank || r == bestRank && r > 0 && name < best {
+			best, bestRank = name, r
+		}
+	}
+
+	return best
+}
+
+// parseROMAddr parses a ROM address: a number, a label or FILE:LINE,
+// which is the first instruction of the line. FILE may be left out and
+// local labels belong to the global label PC is in.
+func (d *debugger) parseROMAddr(str string) (uint16, error) {
+	name := str
+
+	if strings.HasPrefix(str, ".") {
+		name = d.scopeLabel(d.cpu.PC, str)
+	}
+
+	if symbol, ok := d.symbols[name]; ok && symbol.Kind == hack.S_LABEL {
+		return symbol.Addr, nil
+	}
+
+	if addr, err := strconv.ParseUint(str, 0, 16); err == nil && addr < ROM_SIZE {
+		return uint16(addr), nil
+	}
+
+	if i := strings.LastIndex(str, ":"); i >= 0 {
+		if num, err := strconv.Atoi(str[i+1:]); err == nil {
+			if addr, ok := d.lineAddr(str[:i], num); ok {
+				return addr, nil
+			}
+			return 0, fmt.Errorf("no code at line \"%s\"", str)
+		}
+	}
+
+	return 0, fmt.Errorf("bad ROM address \"%s\"", str)
+}
+
+// scopeLabel returns the name the assembler gives a local label used at
+// addr: it's prefixed by the last global label before addr. When several
+// global labels share the address, the one defining the label is taken.
+func (d *debugger) scopeLabel(addr uint16, local string) string {
+	best, bestKey := "", -1
+
+	for name, symbol := range d.symbols {
+		if symbol.Kind != hack.S_LABEL || symbol.Addr > addr || strings.Contains(name, "$") || d.isLocalLabel(name) {
+			continue
+		}
+
+		key := 2 * int(symbol.Addr)
+
+		if d.symbols[name+local].Kind == hack.S_LABEL {
+			key++
+		}
+
+		if key > bestKey || key == bestKey && name < best {
+			best, bestKey = name, key
+		}
+	}
+
+	return best + local
+}
+
+// isLocalLabel reports whether name is a local label scoped by the
+// assembler, a global label followed by the local name
+func (d *debugger) isLocalLabel(name string) bool {
+	for i := 1; i < len(name); i++ {
+		if name[i] == '.' && d.symbols[name[:i]].Kind == hack.S_LABEL {
+			return true
+		}
+	}
+	return false
+}
+
+// lineAddr returns the first address of code from line num of file, any
+// file matches an empty name and names match by their end
+func (d *debugger) lineAddr(file string, num int) (uint16, bool) {
+	found, best := false, uint16(0)
+
+	for addr, line := range d.source {
+		if line.num == num && strings.HasSuffix(line.file, file) && (!found || addr < best) {
+			found, best = true, addr
+		}
+	}
+
+	return best, found
+}
+
+// codeLine returns the first address of code from line num of file or
+// the closest line after it with code, and that line
+func (d *debugger) codeLine(file string, num int) (addr uint16, line int, ok bool) {
+	for _, l := range d.source {
+		if l.num >= num && strings.HasSuffix(l.file, file) && (!ok || l.num < line) {
+			line, ok = l.num, true
+		}
+	}
+
+	if !ok {
+		return 0, 0, false
+	}
+
+	addr, _ = d.lineAddr(file, line)
+	return addr, line, true
+}
+
+// parseRAMAddr parses a RAM address: a number or a symbol other than a
+// label
+func (d *debugger) parseRAMAddr(str string) (uint16, error) {
+	if symbol, ok := d.symbols[str]; ok && symbol.Kind != hack.S_LABEL && str != hack.VAR {
+		return symbol.Addr, nil
+	}
+
+	return parseMemAddr(str)
+}
+
+// breakAt adds a breakpoint or watchpoint and returns it
+func (d *debugger) breakAt(addr uint16, watch bool) breakpoint {
+	b := breakpoint{d.nextID, addr, watch}
+	d.breakpoints = append(d.breakpoints, b)
+	d.nextID++
+	return b
+}
+
+// remove deletes the breakpoint or watchpoint with the ID
+func (d *debugger) remove(id int) bool {
+	for i, b := range d.breakpoints {
+		if b.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// resume executes instructions until done reports true after one of
+// them, a breakpoint or watchpoint triggers, the program halts, the
+// cycle limit is reached or it's interrupted. Breakpoints at the current
+// instruction don't stop it.
+func (d *debugger) resume(done func() bool) stop {
+	cpu := d.cpu
+	values := map[uint16]uint16{}
+
+	for n := 0; ; {
+		if cpu.halted || int(cpu.PC) >= cpu.size {
+			cpu.halted = true
+			return stop{Reason: STOP_HALT, Cycles: n}
+		}
+
+		if n >= d.cycles {
+			return stop{Reason: STOP_LIMIT, Cycles: n}
+		}
+
+		for _, b := range d.breakpoints {
+			if b.Watch {
+				values[b.Addr] = cpu.RAM[b.Addr]
+			}
+		}
+
+		cpu.step()
+		n++
+
+		for _, b := range d.breakpoints {
+			if b.Watch && cpu.RAM[b.Addr] != values[b.Addr] {
+				return stop{STOP_WATCHPOINT, b.ID, b.Addr, values[b.Addr], cpu.RAM[b.Addr], n}
+			}
+		}
+
+		if cpu.halted {
+			return stop{Reason: STOP_HALT, Cycles: n}
+		}
+
+		if done() {
+			return stop{Reason: STOP_STEP, Cycles: n}
+		}
+
+		if d.interrupt != nil && n%INTERRUPT_CYCLES == 0 && d.interrupt() {
+			return stop{Reason: STOP_INTERRUPT, Cycles: n}
+		}
+
+		for _, b := range d.breakpoints {
+			if !b.Watch && b.Addr == cpu.PC {
+				return stop{Reason: STOP_BREAKPOINT, ID: b.ID, Addr: b.Addr, Cycles: n}
+			}
+		}
+	}
+}
+
+// step executes n instructions
+func (d *debugger) step(n int) stop {
+	return d.resume(func() bool {
+		n--
+		return n <= 0
+	})
+}
+
+// next executes the current source line stepping over calls: it stops at
+// the instruction following the line, or at another line it jumps to.
+// Once the stack pointer grew the line called a routine, which has to
+// return to the following instruction.
+func (d *debugger) next() stop {
+	line, ok := d.source[d.cpu.PC]
+
+	if !ok {
+		return d.step(1)
+	}
+
+	end := d.cpu.PC
+
+	for d.source[end+1].same(line) {
+		end++
+	}
+
+	sp, called := int16(d.cpu.RAM[0]), false
+
+	return d.resume(func() bool {
+		pc := d.cpu.PC
+		called = called || int16(d.cpu.RAM[0]) > sp
+		return pc == end+1 || !called && !d.source[pc].same(line)
+	})
+}
+
+// stepLine executes the current source line stepping into calls: it
+// stops at the first instruction of any other line
+func (d *debugger) stepLine() stop {
+	line, ok := d.source[d.cpu.PC]
+
+	if !ok {
+		return d.step(1)
+	}
+
+	return d.resume(func() bool {
+		return !d.source[d.cpu.PC].same(line)
+	})
+}
+
+// finish executes until the routine returns: RET pops the return address
+// below the current stack pointer and jumps to it
+func (d *debugger) finish() stop {
+	sp, pc := int16(d.cpu.RAM[0]), d.cpu.PC
+
+	return d.resume(func() bool {
+		prev := pc
+		pc = d.cpu.PC
+		top := d.cpu.RAM[0]
+		return int16(top) < sp && pc != prev+1 && int(top) < RAM_SIZE && pc == d.cpu.RAM[top]
+	})
+}
+
+// sourceText returns the source line of the instruction at addr, read
+// from its file when possible, and the position of the line
+func (d *debugger) sourceText(addr uint16) (pos, text string, ok bool) {
+	line, ok := d.source[addr]
+
+	if !ok {
+		return "", "", false
+	}
+
+	pos, text = fmt.Sprintf("line %d", line.num), line.text
+
+	if line.file != "" {
+		pos = fmt.Sprintf("%s:%d", line.file, line.num)
+
+		if lines := d.fileLines(line.file); line.num <= len(lines) {
+			text = strings.TrimSpace(lines[line.num-1])
+		}
+	}
+
+	return pos, text, true
+}
+
+// fileLines returns the lines of a source file, nil when it can't be read
+func (d *debugger) fileLines(file string) []string {
+	lines, ok := d.files[file]
+
+	if !ok {
+		if src, err := ioutil.ReadFile(file); err == nil {
+			lines = strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n")
+		}
+		d.files[file] = lines
+	}
+
+	return lines
+}
+
+// location describes the instruction at addr: its address, label and
+// source line, or its disassembly without a source
+func (d *debugger) location(addr uint16) string {
+	loc := fmt.Sprintf("%d", addr)
+
+	if int(addr) >= d.cpu.size {
+		return loc + "  end of program"
+	}
+
+	if name := d.romName(addr); name != "" {
+		loc += " <" + name + ">"
+	}
+
+	if pos, text, ok := d.sourceText(addr); ok {
+		return fmt.Sprintf("%s  %s  %s", loc, pos, text)
+	}
+
+	instr, err := hack.DisassembleWord(d.cpu.ROM[addr])
+
+	if err != nil {
+		instr = fmt.Sprintf("%016b", d.cpu.ROM[addr])
+	}
+
+	return fmt.Sprintf("%s  %s", loc, instr)
+}
+
+// ramString formats a RAM word with its symbolic name
+func (d *debugger) ramString(addr uint16) string {
+	name := ""
+
+	if n := d.ramName(addr); n != "" {
+		name = " (" + n + ")"
+	}
+
+	return fmt.Sprintf("RAM[%d]%s = %d", addr, name, int16(d.cpu.RAM[addr]))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+const DEBUG_SRC = `.var i
+    @256
+    D=A
+    @SP
+    M=D
+    @3
+    D=A
+    @i
+    M=D
+(LOOP)
+    CALL DEC
+    @LOOP
+    D;JGT
+    HALT
+(DEC)
+    @i
+    MD=M-1
+    RET
+`
+
+// debugSession runs the commands on DEBUG_SRC saved as test.asm in a
+// temporary directory, the directory is left out of the output
+func debugSession(t *testing.T, commands ...string) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.asm")
+
+	if err := ioutil.WriteFile(path, []byte(DEBUG_SRC), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := hack.New(path).AssembleFiles(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	d := newDebugger(prog.Code, prog.RAM(), prog.Symbols, programSource(prog))
+	d.repl(strings.NewReader(strings.Join(commands, "\n")+"\n"), out)
+
+	return strings.NewReplacer(DEBUG_PROMPT, "", dir+string(filepath.Separator), "").Replace(out.String())
+}
+
+func TestDebugBreakpoints(t *testing.T) {
+	have := debugSession(t, "b DEC", "c", "regs", "", "p i", "d 1", "w i", "c", "info", "x SP 2", "q")
+
+	expected := strings.Join([]string{
+		"0  test.asm:2  @256",
+		"Breakpoint 1 at 24 <DEC>  test.asm:16  @i",
+		"Breakpoint 1",
+		"24 <DEC>  test.asm:16  @i",
+		"A  = 24",
+		"D  = 3",
+		"PC = 24 <DEC>",
+		"Breakpoint 1",
+		"24 <DEC>  test.asm:16  @i",
+		"RAM[16] (i) = 2",
+		"Watchpoint 2: RAM[16] (i) = 2",
+		"Watchpoint 2: RAM[16] (i) = 1 changed from 2",
+		"26 <DEC+2>  test.asm:18  RET",
+		"Watchpoint 2: RAM[16] (i) = 1",
+		"RAM[0] (SP) = 257",
+		"RAM[1] (LCL) = 0",
+	}, "\n") + "\n"
+
+	if have != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, have)
+	}
+}
+
+func TestDebugStepping(t *testing.T) {
+	have := debugSession(t, "b test.asm:11", "c", "n", "s 2", "n", "c", "c", "c", "reset", "b 1000", "b NOPE", "step x", "quit")
+
+	expected := strings.Join([]string{
+		"0  test.asm:2  @256",
+		"Breakpoint 1 at 8 <LOOP>  test.asm:11  CALL DEC",
+		"Breakpoint 1",
+		"8 <LOOP>  test.asm:11  CALL DEC",
+		"20 <LOOP+12>  test.asm:12  @LOOP",
+		"8 <LOOP>  test.asm:11  CALL DEC",
+		"20 <LOOP+12>  test.asm:12  @LOOP",
+		"Breakpoint 1",
+		"8 <LOOP>  test.asm:11  CALL DEC",
+		"Halted after 22 cycles",
+		"22 <LOOP+14>  test.asm:14  HALT",
+		"Halted after 0 cycles",
+		"22 <LOOP+14>  test.asm:14  HALT",
+		"0  test.asm:2  @256",
+		"Breakpoint 2 at 1000  end of program",
+		`bad ROM address "NOPE"`,
+		`bad step count "x"`,
+	}, "\n") + "\n"
+
+	if have != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, have)
+	}
+}
+
+func TestReadSourceFiles(t *testing.T) {
+	src := ".macro INC x\n@x\nM=M+1\n.endm\n(START)\nINC i\n@START\n0;JMP\n"
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listing, sourceMap := &bytes.Buffer{}, &bytes.Buffer{}
+	writeListing(listing, "test.asm", src, prog)
+	writeSourceMap(sourceMap, prog)
+
+	fromListing, err := readListing(listing)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromMap, err := readSourceMap(sourceMap)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[uint16]sourceLine{
+		0: {"", 6, "INC i"},
+		1: {"", 6, "INC i"},
+		2: {"", 7, "@START"},
+		3: {"", 8, "0;JMP"},
+	}
+
+	if !reflect.DeepEqual(fromListing, expected) {
+		t.Errorf("Expected %v from the listing, have %v", expected, fromListing)
+	}
+
+	expected[0] = sourceLine{"test.asm", 6, "@i"}
+	expected[1] = sourceLine{"test.asm", 6, "M=M+1"}
+	expected[2] = sourceLine{"test.asm", 7, "@START"}
+	expected[3] = sourceLine{"test.asm", 8, "0;JMP"}
+
+	if !reflect.DeepEqual(fromMap, expected) {
+		t.Errorf("Expected %v from the source map, have %v", expected, fromMap)
+	}
+}
+
+func TestDebugLocalLabels(t *testing.T) {
+	src := "(MAIN)\n(.loop)\n@.loop\nD;JGT\n(Sys.init)\n(.loop)\n@.loop\n0;JMP\n"
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDebugger(prog.Code, nil, prog.Symbols, programSource(prog))
+	examples := map[uint16]uint16{0: 0, 1: 0, 2: 2, 3: 2}
+
+	for pc, expected := range examples {
+		d.cpu.PC = pc
+		addr, err := d.parseROMAddr(".loop")
+
+		if err != nil || addr != expected {
+			t.Errorf("Expected .loop at PC %d to be %d, have %d %v", pc, expected, addr, err)
+		}
+	}
+
+	if _, err := d.parseROMAddr(".nope"); err == nil || err.Error() != `bad ROM address ".nope"` {
+		t.Errorf("Expected an error for .nope, have %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+// dotEscape escapes str for a Graphviz string
+func dotEscape(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(str)
+}
+
+// writeDot writes the control flow graph of prog in Graphviz DOT. Blocks
+// list their labels and source lines, conditional jumps are labelled by
+// their condition, unconditional jumps are bold and fall-throughs dashed.
+// Jumps to computed addresses go to the "computed" node, code running
+// past the end of the program to the "end" node.
+func writeDot(w io.Writer, name string, prog *hack.Program) error {
+	g := hack.NewCFG(prog)
+	bw := bufio.NewWriter(w)
+	var computed, end bool
+
+	fmt.Fprintf(bw, "digraph \"%s\" {\n", dotEscape(name))
+	fmt.Fprintf(bw, "\tnode [shape=box fontname=monospace];\n")
+
+	for i, b := range g.Blocks {
+		var label strings.Builder
+
+		for _, l := range b.Labels {
+			fmt.Fprintf(&label, "(%s)\\l", dotEscape(l))
+		}
+
+		for _, line := range prog.Lines[b.Start:b.End] {
+			src := line.Src
+
+			if j := strings.Index(src, hack.COMMENT); j >= 0 {
+				src = src[:j]
+			}
+
+			fmt.Fprintf(&label, "%5d  %s\\l", line.Addr, dotEscape(strings.TrimSpace(src)))
+		}
+
+		fmt.Fprintf(bw, "\tb%d [label=\"%s\"];\n", i, label.String())
+
+		var jmp string
+
+		for _, t := range prog.Lines[b.End-1].Tokens {
+			if t.Type == hack.T_JMP {
+				jmp = t.Val
+			}
+		}
+
+		for _, e := range b.Edges {
+			switch e.Kind {
+			case hack.E_FALLTHROUGH:
+				fmt.Fprintf(bw, "\tb%d -> b%d [style=dashed];\n", i, e.To)
+			case hack.E_JUMP:
+				fmt.Fprintf(bw, "\tb%d -> b%d [label=%s style=bold];\n", i, e.To, jmp)
+			case hack.E_BRANCH:
+				fmt.Fprintf(bw, "\tb%d -> b%d [label=%s];\n", i, e.To, jmp)
+			}
+		}
+
+		if g.Indirect(i) {
+			computed = true
+			fmt.Fprintf(bw, "\tb%d -> computed [label=%s style=bold];\n", i, jmp)
+		}
+
+		if b.Exit {
+			end = true
+			fmt.Fprintf(bw, "\tb%d -> end [style=dashed];\n", i)
+		}
+	}
+
+	if computed {
+		fmt.Fprintf(bw, "\tcomputed [shape=ellipse label=\"computed address\"];\n")
+	}
+
+	if end {
+		fmt.Fprintf(bw, "\tend [shape=ellipse label=\"end of code\"];\n")
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+func TestWriteDot(t *testing.T) {
+	src := "@i\nD=M\n@LOOP\nD;JGT // more\n(LOOP)\n@LOOP\n0;JMP\n"
+
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = writeDot(buf, "test.asm", prog); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		`digraph "test.asm" {`,
+		"\tnode [shape=box fontname=monospace];",
+		`	b0 [label="    0  @i\l    1  D=M\l    2  @LOOP\l    3  D;JGT\l"];`,
+		"\tb0 -> b1 [label=JGT];",
+		"\tb0 -> b1 [style=dashed];",
+		`	b1 [label="(LOOP)\l    4  @LOOP\l    5  0;JMP\l"];`,
+		"\tb1 -> b1 [label=JMP style=bold];",
+		"}",
+	}, "\n") + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, buf.String())
+	}
+}
+
+func TestWriteDotComputed(t *testing.T) {
+	src := "@R0\nA=M\n0;JMP\nD=0\n"
+
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = writeDot(buf, "test.asm", prog); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"\tb0 -> computed [label=JMP style=bold];",
+		"\tb1 -> end [style=dashed];",
+		"\tcomputed [shape=ellipse label=\"computed address\"];",
+		"\tend [shape=ellipse label=\"end of code\"];",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, buf.String())
+		}
+	}
+}
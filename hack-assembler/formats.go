@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+// Format writes machine code in some file format and reads it back
+type Format interface {
+	Write(w io.Writer, code []uint16) error
+	Read(r io.Reader) ([]uint16, error)
+}
+
+var formats = map[string]Format{
+	"hack":    hackFormat{},
+	"bin":     binFormat{},
+	"ihex":    ihexFormat{},
+	"logisim": logisimFormat{},
+	"coe":     coeFormat{},
+	"mif":     mifFormat{},
+}
+
+// formatExts maps file extensions to the formats usually stored in them
+var formatExts = map[string]string{
+	".hack": "hack",
+	".bin":  "bin",
+	".hex":  "ihex",
+	".ihex": "ihex",
+	".rom":  "logisim",
+	".coe":  "coe",
+	".mif":  "mif",
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// findFormat returns the format called name or, when name is empty,
+// the format matching the extension of path
+func findFormat(name, path string) (Format, bool) {
+	if name == "" {
+		name = formatExts[strings.ToLower(filepath.Ext(path))]
+	}
+
+	f, ok := formats[name]
+	return f, ok
+}
+
+// codeFormat returns the format called name, or the one matching the
+// extension of path, defaulting to the hack format
+func codeFormat(name, path string) (Format, error) {
+	if f, ok := findFormat(name, path); ok {
+		return f, nil
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("unknown format \"%s\", known formats: %s", name, formatNames())
+	}
+
+	return formats["hack"], nil
+}
+
+// hackFormat is the text format of nand2tetris: a line of 16 binary
+// digits per instruction
+type hackFormat struct{}
+
+func (hackFormat) Write(w io.Writer, code []uint16) error {
+	_, err := io.Copy(w, newCodeReader(code))
+	return err
+}
+
+func (hackFormat) Read(r io.Reader) ([]uint16, error) {
+	return readCode(r)
+}
+
+func newCodeReader(code []uint16) io.Reader {
+	buf := make([]string, 0, len(code))
+	for _, instruction := range code {
+		buf = append(buf, fmt.Sprintf("%016b\n", instruction))
+	}
+
+	return strings.NewReader(strings.Join(buf, ""))
+}
+
+// readCode reads machine code in the format produced by newCodeReader
+func readCode(r io.Reader) (code []uint16, err error) {
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.Join(strings.Fields(strings.Split(scanner.Text(), hack.COMMENT)[0]), "")
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if len(line) != 16 {
+			return nil, fmt.Errorf("line %d: expected 16 binary digits, have \"%s\"", n, line)
+		}
+
+		i, err := strconv.ParseUint(line, 2, 16)
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: can't parse \"%s\"", n, line)
+		}
+
+		if len(code) == ROM_SIZE {
+			return nil, fmt.Errorf("line %d: code beyond the ROM at address %d", n, ROM_SIZE)
+		}
+
+		code = append(code, uint16(i))
+	}
+
+	return code, scanner.Err()
+}
+
+// binFormat stores instructions as raw big-endian 16 bit words
+type binFormat struct{}
+
+func (binFormat) Write(w io.Writer, code []uint16) error {
+	return binary.Write(w, binary.BigEndian, code)
+}
+
+func (binFormat) Read(r io.Reader) ([]uint16, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, 2*ROM_SIZE+1))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > 2*ROM_SIZE {
+		return nil, fmt.Errorf("code beyond the ROM at address %d", ROM_SIZE)
+	}
+
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("odd number of bytes: %d", len(data))
+	}
+
+	code := make([]uint16, len(data)/2)
+
+	for i := range code {
+		code[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+
+	return code, nil
+}
+
+const (
+	IHEX_DATA        = 0
+	IHEX_EOF         = 1
+	IHEX_EXT_SEGMENT = 2
+	IHEX_EXT_LINEAR  = 4
+
+	IHEX_RECORD_SIZE = 16
+)
+
+// ihexFormat is Intel HEX with byte addresses and big-endian words
+type ihexFormat struct{}
+
+func writeIhexRecord(w io.Writer, kind byte, addr uint16, data []byte) error {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + kind
+	line := fmt.Sprintf(":%02X%04X%02X", len(data), addr, kind)
+
+	for _, b := range data {
+		line += fmt.Sprintf("%02X", b)
+		sum += b
+	}
+
+	_, err := fmt.Fprintf(w, "%s%02X\n", line, -sum)
+	return err
+}
+
+func (ihexFormat) Write(w io.Writer, code []uint16) error {
+	if len(code) > ROM_SIZE {
+		return fmt.Errorf("code beyond the ROM at address %d", ROM_SIZE)
+	}
+
+	data := make([]byte, len(code)*2)
+
+	for i, word := range code {
+		binary.BigEndian.PutUint16(data[i*2:], word)
+	}
+
+	for addr := 0; addr < len(data); addr += IHEX_RECORD_SIZE {
+		end := addr + IHEX_RECORD_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if err := writeIhexRecord(w, IHEX_DATA, uint16(addr), data[addr:end]); err != nil {
+			return err
+		}
+	}
+
+	return writeIhexRecord(w, IHEX_EOF, 0, nil)
+}
+
+func (ihexFormat) Read(r io.Reader) ([]uint16, error) {
+	var (
+		data []byte
+		base int
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		if line[0] != ':' || len(line) < 11 || len(line)%2 != 1 {
+			return nil, fmt.Errorf("line %d: malformed record", num)
+		}
+
+		record := make([]byte, (len(line)-1)/2)
+		var sum byte
+
+		for i := range record {
+			b, err := strconv.ParseUint(line[1+i*2:3+i*2], 16, 8)
+
+			if err != nil {
+				return nil, fmt.Errorf("line %d: malformed record", num)
+			}
+
+			record[i] = byte(b)
+			sum += byte(b)
+		}
+
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d: bad checksum", num)
+		}
+
+		size, kind := int(record[0]), record[3]
+		addr := int(record[1])<<8 | int(record[2])
+		payload := record[4 : len(record)-1]
+
+		if len(payload) != size {
+			return nil, fmt.Errorf("line %d: record size mismatch", num)
+		}
+
+		switch kind {
+		case IHEX_DATA:
+			start := base + addr
+
+			if start+size > 2*ROM_SIZE {
+				return nil, fmt.Errorf("line %d: data beyond the ROM at address %d", num, start)
+			}
+
+			for len(data) < start+size {
+				data = append(data, 0)
+			}
+
+			copy(data[start:], payload)
+
+		case IHEX_EOF:
+			return bytesToWords(data), nil
+
+		case IHEX_EXT_SEGMENT, IHEX_EXT_LINEAR:
+			if size != 2 {
+				return nil, fmt.Errorf("line %d: expected a 2 byte address in record type %d", num, kind)
+			}
+
+			base = int(payload[0])<<8 | int(payload[1])
+
+			if kind == IHEX_EXT_SEGMENT {
+				base <<= 4
+			} else {
+				base <<= 16
+			}
+
+		default:
+			return nil, fmt.Errorf("line %d: unsupported record type %d", num, kind)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("missing end of file record")
+}
+
+func bytesToWords(data []byte) []uint16 {
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+
+	code := make([]uint16, len(data)/2)
+
+	for i := range code {
+		code[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+
+	return code
+}
+
+const (
+	LOGISIM_HEADER   = "v2.0 raw"
+	LOGISIM_PER_LINE = 8
+	LOGISIM_MIN_RUN  = 4
+)
+
+// logisimFormat is the image format of Logisim ROM and RAM components,
+// runs of equal words are stored as COUNT*VALUE
+type logisimFormat struct{}
+
+func (logisimFormat) Write(w io.Writer, code []uint16) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(LOGISIM_HEADER + "\n")
+
+	n := 0
+
+	for i := 0; i < len(code); {
+		run := 1
+		for i+run < len(code) && code[i+run] == code[i] {
+			run++
+		}
+
+		if run >= LOGISIM_MIN_RUN {
+			fmt.Fprintf(bw, "%d*%x", run, code[i])
+		} else {
+			run = 1
+			fmt.Fprintf(bw, "%x", code[i])
+		}
+
+		i += run
+		n++
+
+		if n%LOGISIM_PER_LINE == 0 || i == len(code) {
+			bw.WriteString("\n")
+		} else {
+			bw.WriteString(" ")
+		}
+	}
+
+	return bw.Flush()
+}
+
+func (logisimFormat) Read(r io.Reader) (code []uint16, err error) {
+	scanner := bufio.NewScanner(r)
+
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != LOGISIM_HEADER {
+		return nil, fmt.Errorf("missing \"%s\" header", LOGISIM_HEADER)
+	}
+
+	for num := 2; scanner.Scan(); num++ {
+		line := strings.Split(scanner.Text(), "#")[0]
+
+		for _, field := range strings.Fields(line) {
+			count := uint64(1)
+			value := field
+
+			if i := strings.Index(field, "*"); i >= 0 {
+				if count, err = strconv.ParseUint(field[:i], 10, 16); err != nil {
+					return nil, fmt.Errorf("line %d: bad count \"%s\"", num, field)
+				}
+				value = field[i+1:]
+			}
+
+			word, err := strconv.ParseUint(value, 16, 16)
+
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad value \"%s\"", num, field)
+			}
+
+			if count > uint64(ROM_SIZE-len(code)) {
+				return nil, fmt.Errorf("line %d: code beyond the ROM at address %d", num, ROM_SIZE)
+			}
+
+			for ; count > 0; count-- {
+				code = append(code, uint16(word))
+			}
+		}
+	}
+
+	return code, scanner.Err()
+}
+
+// coeFormat is the Xilinx memory initialization format
+type coeFormat struct{}
+
+func (coeFormat) Write(w io.Writer, code []uint16) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("memory_initialization_radix=2;\n")
+	bw.WriteString("memory_initialization_vector=\n")
+
+	for i, word := range code {
+		sep := ","
+		if i == len(code)-1 {
+			sep = ";"
+		}
+		fmt.Fprintf(bw, "%016b%s\n", word, sep)
+	}
+
+	if len(code) == 0 {
+		bw.WriteString(";\n")
+	}
+
+	return bw.Flush()
+}
+
+func (coeFormat) Read(r io.Reader) ([]uint16, error) {
+	src, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		code  []uint16
+		radix = 10
+	)
+
+	var lines []string
+
+	for _, line := range strings.Split(string(src), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), ";") {
+			lines = append(lines, line)
+		}
+	}
+
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		statement = strings.TrimSpace(statement)
+
+		if statement == "" {
+			continue
+		}
+
+		parts := strings.SplitN(statement, "=", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed statement \"%s\"", statement)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "memory_initialization_radix":
+			if radix, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("bad radix \"%s\"", value)
+			}
+
+		case "memory_initialization_vector":
+			fields := strings.FieldsFunc(value, func(ch rune) bool {
+				return ch == ',' || ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+			})
+
+			for _, field := range fields {
+				word, err := strconv.ParseUint(field, radix, 16)
+
+				if err != nil {
+					return nil, fmt.Errorf("bad value \"%s\"", field)
+				}
+
+				if len(code) == ROM_SIZE {
+					return nil, fmt.Errorf("code beyond the ROM at address %d", ROM_SIZE)
+				}
+
+				code = append(code, uint16(word))
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown key \"%s\"", key)
+		}
+	}
+
+	return code, nil
+}
+
+// mifFormat is the Altera memory initialization format
+type mifFormat struct{}
+
+var mifRadixes = map[string]int{
+	"BIN": 2,
+	"OCT": 8,
+	"DEC": 10,
+	"UNS": 10,
+	"HEX": 16,
+}
+
+func (mifFormat) Write(w io.Writer, code []uint16) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "WIDTH=16;\nDEPTH=%d;\n\n", len(code))
+	bw.WriteString("ADDRESS_RADIX=UNS;\nDATA_RADIX=BIN;\n\nCONTENT BEGIN\n")
+
+	for addr, word := range code {
+		fmt.Fprintf(bw, "\t%d : %016b;\n", addr, word)
+	}
+
+	bw.WriteString("END;\n")
+	return bw.Flush()
+}
+
+// stripMifComments removes "-- line" and "% block %" comments
+func stripMifComments(src string) string {
+	var lines []string
+
+	for _, line := range strings.Split(src, "\n") {
+		lines = append(lines, strings.Split(line, "--")[0])
+	}
+
+	parts := strings.Split(strings.Join(lines, "\n"), "%")
+
+	for i := 1; i < len(parts); i += 2 {
+		parts[i] = ""
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (mifFormat) Read(r io.Reader) ([]uint16, error) {
+	src, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		code      []uint16
+		depth     = -1
+		addrRadix = 16
+		dataRadix = 16
+		content   = false
+	)
+
+	for _, statement := range strings.Split(stripMifComments(string(src)), ";") {
+		statement = strings.TrimSpace(statement)
+		upper := strings.ToUpper(statement)
+
+		if statement == "" || upper == "END" {
+			continue
+		}
+
+		if strings.HasPrefix(upper, "CONTENT") {
+			i := strings.Index(upper, "BEGIN")
+
+			if i < 0 {
+				return nil, fmt.Errorf("expected CONTENT BEGIN")
+			}
+
+			content = true
+			statement = strings.TrimSpace(statement[i+len("BEGIN"):])
+			upper = strings.ToUpper(statement)
+		}
+
+		if !content {
+			parts := strings.SplitN(upper, "=", 2)
+
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("malformed statement \"%s\"", statement)
+			}
+
+			key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+			switch key {
+			case "WIDTH":
+				if value != "16" {
+					return nil, fmt.Errorf("unsupported width %s", value)
+				}
+			case "DEPTH":
+				if depth, err = strconv.Atoi(value); err != nil || depth > ROM_SIZE {
+					return nil, fmt.Errorf("bad depth \"%s\"", value)
+				}
+			case "ADDRESS_RADIX", "DATA_RADIX":
+				radix, ok := mifRadixes[value]
+
+				if !ok {
+					return nil, fmt.Errorf("unknown radix \"%s\"", value)
+				}
+
+				if key == "ADDRESS_RADIX" {
+					addrRadix = radix
+				} else {
+					dataRadix = radix
+				}
+			default:
+				return nil, fmt.Errorf("unknown key \"%s\"", key)
+			}
+
+			continue
+		}
+
+		if depth < 0 {
+			return nil, fmt.Errorf("missing DEPTH")
+		}
+
+		if code == nil {
+			code = make([]uint16, depth)
+		}
+
+		parts := strings.SplitN(statement, ":", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed content \"%s\"", statement)
+		}
+
+		from, to, err := parseMifAddrs(strings.TrimSpace(parts[0]), addrRadix)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var words []uint16
+
+		for _, field := range strings.Fields(parts[1]) {
+			word, err := strconv.ParseUint(field, dataRadix, 16)
+
+			if err != nil {
+				return nil, fmt.Errorf("bad value \"%s\"", field)
+			}
+
+			words = append(words, uint16(word))
+		}
+
+		if len(words) == 0 {
+			return nil, fmt.Errorf("missing value for address %d", from)
+		}
+
+		if len(words) > 1 {
+			to = from + len(words) - 1
+		}
+
+		if to >= depth {
+			return nil, fmt.Errorf("address %d out of range 0..%d", to, depth-1)
+		}
+
+		for addr := from; addr <= to; addr++ {
+			code[addr] = words[(addr-from)%len(words)]
+		}
+	}
+
+	if !content {
+		return nil, fmt.Errorf("missing CONTENT BEGIN")
+	}
+
+	if code == nil {
+		code = make([]uint16, depth)
+	}
+
+	return code, nil
+}
+
+// parseMifAddrs parses an address or an address range like [0..15]
+func parseMifAddrs(str string, radix int) (from, to int, err error) {
+	bounds := []string{str}
+
+	if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+		bounds = strings.SplitN(str[1:len(str)-1], "..", 2)
+
+		if len(bounds) != 2 {
+			return 0, 0, fmt.Errorf("bad address range \"%s\"", str)
+		}
+	}
+
+	addrs := make([]int, len(bounds))
+
+	for i, bound := range bounds {
+		addr, err := strconv.ParseUint(strings.TrimSpace(bound), radix, 15)
+
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad address \"%s\"", str)
+		}
+
+		addrs[i] = int(addr)
+	}
+
+	from, to = addrs[0], addrs[len(addrs)-1]
+
+	if to < from {
+		return 0, 0, fmt.Errorf("bad address range \"%s\"", str)
+	}
+
+	return
+}
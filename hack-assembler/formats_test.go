@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatsRoundTrip(t *testing.T) {
+	code := mustCompile(t, "@2\nD=A\n@3\nD=D+A\n@0\nM=D\n(END)\n@END\n0;JMP\n")
+	code = append(code, 0, 0, 0, 0, 0, 0xFFFF)
+
+	for name, f := range formats {
+		buf := &bytes.Buffer{}
+
+		if err := f.Write(buf, code); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		res, err := f.Read(buf)
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !reflect.DeepEqual(res, code) {
+			t.Errorf("%s: expected %v, have %v", name, code, res)
+		}
+	}
+}
+
+func TestFormatsROMSize(t *testing.T) {
+	for name, f := range formats {
+		for _, size := range []int{ROM_SIZE, ROM_SIZE + 1} {
+			buf := &bytes.Buffer{}
+			err := f.Write(buf, make([]uint16, size))
+
+			if err == nil {
+				_, err = f.Read(buf)
+			}
+
+			if (err == nil) != (size == ROM_SIZE) {
+				t.Errorf("%s: expected an error only beyond %d words, have %v for %d", name, ROM_SIZE, err, size)
+			}
+		}
+	}
+
+	if _, err := formats["logisim"].Read(strings.NewReader("v2.0 raw\n30000*0 30000*0\n")); err == nil || !strings.Contains(err.Error(), "beyond the ROM") {
+		t.Errorf("Expected beyond the ROM error, have %v", err)
+	}
+}
+
+func TestFindFormat(t *testing.T) {
+	for path, name := range map[string]string{
+		"prog.hack": "hack",
+		"prog.BIN":  "bin",
+		"prog.hex":  "ihex",
+		"prog.rom":  "logisim",
+		"prog.coe":  "coe",
+		"prog.mif":  "mif",
+	} {
+		f, ok := findFormat("", path)
+
+		if !ok || f != formats[name] {
+			t.Errorf("Expected %s for %s, have %T", name, path, f)
+		}
+	}
+
+	if f, err := codeFormat("", "prog.out"); err != nil || f != formats["hack"] {
+		t.Errorf("Expected hack format by default, have %T, %v", f, err)
+	}
+
+	if _, err := codeFormat("srec", "prog.hack"); err == nil {
+		t.Error("Expected unknown format error")
+	}
+}
+
+func TestWriteBin(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	if err := formats["bin"].Write(buf, []uint16{0xEC10, 0x0002}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), []byte{0xEC, 0x10, 0x00, 0x02}) {
+		t.Errorf("Expected big-endian words, have % X", buf.Bytes())
+	}
+
+	if _, err := formats["bin"].Read(bytes.NewReader([]byte{1, 2, 3})); err == nil {
+		t.Error("Expected odd length error")
+	}
+}
+
+func TestWriteIhex(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	if err := formats["ihex"].Write(buf, []uint16{0x0002, 0xEC10}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ":040000000002EC10FE\n:00000001FF\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, buf.String())
+	}
+}
+
+func TestReadIhexChecksum(t *testing.T) {
+	_, err := formats["ihex"].Read(strings.NewReader(":040000000002EC10FF\n:00000001FF\n"))
+
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Expected checksum error, have %v", err)
+	}
+}
+
+func TestReadIhexBadRecords(t *testing.T) {
+	examples := map[string]string{
+		":00000002FE\n:00000001FF\n":                      "2 byte address",
+		":00000004FC\n:00000001FF\n":                      "2 byte address",
+		":020000040001F9\n:020000000002FC\n:00000001FF\n": "beyond the ROM",
+		":02FFFF000002FE\n:00000001FF\n":                  "beyond the ROM",
+	}
+
+	for src, expected := range examples {
+		_, err := formats["ihex"].Read(strings.NewReader(src))
+
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %s error for %q, have %v", expected, src, err)
+		}
+	}
+}
+
+func TestReadLogisim(t *testing.T) {
+	src := "v2.0 raw\n# comment\n2 ec10\n3*0 ffff\n"
+
+	code, err := formats["logisim"].Read(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint16{2, 0xEC10, 0, 0, 0, 0xFFFF}
+
+	if !reflect.DeepEqual(code, expected) {
+		t.Errorf("Expected %v, have %v", expected, code)
+	}
+}
+
+func TestReadCoe(t *testing.T) {
+	src := "; generated\nmemory_initialization_radix=16;\nmemory_initialization_vector=\n0002, EC10,\n0000;\n"
+
+	code, err := formats["coe"].Read(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint16{2, 0xEC10, 0}
+
+	if !reflect.DeepEqual(code, expected) {
+		t.Errorf("Expected %v, have %v", expected, code)
+	}
+}
+
+func TestReadMif(t *testing.T) {
+	src := `-- generated
+WIDTH=16;
+DEPTH=6;
+ADDRESS_RADIX=HEX;
+DATA_RADIX=HEX;
+CONTENT BEGIN
+	0 : 0002; % two %
+	1 : EC10;
+	[2..4] : 0;
+	5 : FFFF;
+END;
+`
+
+	code, err := formats["mif"].Read(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint16{2, 0xEC10, 0, 0, 0, 0xFFFF}
+
+	if !reflect.DeepEqual(code, expected) {
+		t.Errorf("Expected %v, have %v", expected, code)
+	}
+}
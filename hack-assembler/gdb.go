@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// GDB_RAM is the byte address of RAM in the memory seen by GDB, ROM
+	// starts at 0. Words are 2 bytes, little-endian.
+	GDB_RAM = 0x10000
+
+	GDB_INTERRUPT = 0x03
+
+	// GDB_PACKET_SIZE is the longest packet accepted, memory is
+	// transferred as 2 hex digits a byte so at most half of it fits
+	GDB_PACKET_SIZE = 0x4000
+	GDB_MAX_MEMORY  = GDB_PACKET_SIZE / 2
+
+	SIGINT  = 2
+	SIGTRAP = 5
+	SIGXCPU = 24
+)
+
+// GDB_TARGET describes the registers to GDB: A, D and PC, which holds the
+// byte address of the instruction
+const GDB_TARGET = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.nand2tetris.hack">
+    <reg name="a" bitsize="16" type="int16" regnum="0"/>
+    <reg name="d" bitsize="16" type="int16" regnum="1"/>
+    <reg name="pc" bitsize="16" type="code_ptr" regnum="2"/>
+  </feature>
+</target>
+`
+
+// gdbConn is a connection of a GDB client, packets and interrupts are
+// read by a goroutine
+type gdbConn struct {
+	w       io.Writer
+	packets chan string
+	// interrupts receives a value for every interrupt byte
+	interrupts chan bool
+	// done is closed when the session ends
+	done chan bool
+	err  error
+}
+
+func newGDBConn(rw io.ReadWriter) *gdbConn {
+	c := &gdbConn{w: rw, packets: make(chan string), interrupts: make(chan bool, 16), done: make(chan bool)}
+	go c.read(bufio.NewReader(rw))
+	return c
+}
+
+// read splits the input into packets and interrupts. Packets are
+// acknowledged until the client asks to stop with QStartNoAckMode.
+func (c *gdbConn) read(r *bufio.Reader) {
+	defer close(c.packets)
+	noAck := false
+
+	for {
+		ch, err := r.ReadByte()
+
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		switch ch {
+		case GDB_INTERRUPT:
+			select {
+			case c.interrupts <- true:
+			default:
+			}
+			continue
+		case '$':
+		default:
+			continue
+		}
+
+		data, ok, err := readPacket(r)
+
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		sum := make([]byte, 2)
+
+		// the rest of a packet that's too long is skipped looking for '$'
+		if ok {
+			_, err = io.ReadFull(r, sum)
+		}
+
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		if !noAck {
+			ack := "+"
+
+			if !ok || fmt.Sprintf("%02x", checksum(data)) != strings.ToLower(string(sum)) {
+				ack = "-"
+			}
+
+			if _, err = io.WriteString(c.w, ack); err != nil {
+				c.err = err
+				return
+			}
+
+			ok = ack == "+"
+		}
+
+		if !ok {
+			continue
+		}
+
+		select {
+		case c.packets <- data:
+		case <-c.done:
+			return
+		}
+
+		noAck = noAck || data == "QStartNoAckMode"
+	}
+}
+
+// readPacket reads the data of a packet up to '#', ok is false when it's
+// longer than GDB_PACKET_SIZE
+func readPacket(r *bufio.Reader) (data string, ok bool, err error) {
+	var buf strings.Builder
+
+	for buf.Len() <= GDB_PACKET_SIZE {
+		ch, err := r.ReadByte()
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if ch == '#' {
+			return buf.String(), true, nil
+		}
+
+		buf.WriteByte(ch)
+	}
+
+	return "", false, nil
+}
+
+func checksum(data string) (sum byte) {
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return
+}
+
+// send writes a packet, escaping the characters GDB reserves
+func (c *gdbConn) send(data string) error {
+	var buf strings.Builder
+
+	for i := 0; i < len(data); i++ {
+		switch ch := data[i]; ch {
+		case '$', '#', '}', '*':
+			buf.WriteByte('}')
+			buf.WriteByte(ch ^ 0x20)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+
+	escaped := buf.String()
+	_, err := fmt.Fprintf(c.w, "$%s#%02x", escaped, checksum(escaped))
+	return err
+}
+
+// serveGDB answers the packets of a GDB client on rw until it detaches,
+// kills the program or disconnects
+func (d *debugger) serveGDB(rw io.ReadWriter) error {
+	c := newGDBConn(rw)
+	defer close(c.done)
+
+	d.interrupt = func() bool {
+		select {
+		case <-c.interrupts:
+			return true
+		default:
+			return false
+		}
+	}
+	defer func() { d.interrupt = nil }()
+
+	for packet := range c.packets {
+		reply, quit := d.gdbPacket(packet)
+
+		if err := c.send(reply); err != nil {
+			return err
+		}
+
+		if quit {
+			return nil
+		}
+	}
+
+	if c.err == io.EOF {
+		return nil
+	}
+
+	return c.err
+}
+
+// gdbPacket runs the command of a packet and returns the reply, quit is
+// set when the session ends
+func (d *debugger) gdbPacket(packet string) (reply string, quit bool) {
+	if packet == "" {
+		return "", false
+	}
+
+	args := packet[1:]
+
+	switch packet[0] {
+	case '?':
+		return gdbSignal(SIGTRAP), false
+
+	case 'g':
+		return gdbWords(d.cpu.A, d.cpu.D, d.cpu.PC*2), false
+
+	case 'G':
+		words, err := parseGDBWords(args)
+
+		if err != nil || len(words) != 3 {
+			return "E01", false
+		}
+
+		d.cpu.A, d.cpu.D, d.cpu.PC = words[0], words[1], words[2]/2
+		return "OK", false
+
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+
+		if err != nil || n > 2 {
+			return "E01", false
+		}
+
+		return gdbWords([]uint16{d.cpu.A, d.cpu.D, d.cpu.PC * 2}[n]), false
+
+	case 'P':
+		parts := strings.SplitN(args, "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+
+		if err != nil || n > 2 || len(parts) != 2 {
+			return "E01", false
+		}
+
+		words, err := parseGDBWords(parts[1])
+
+		if err != nil || len(words) != 1 {
+			return "E01", false
+		}
+
+		switch n {
+		case 0:
+			d.cpu.A = words[0]
+		case 1:
+			d.cpu.D = words[0]
+		default:
+			d.cpu.PC = words[0] / 2
+		}
+		return "OK", false
+
+	case 'm':
+		addr, length, err := parseGDBRange(args)
+
+		if err != nil {
+			return "E01", false
+		}
+
+		// replies may be shorter than requested
+		if length > GDB_MAX_MEMORY {
+			length = GDB_MAX_MEMORY
+		}
+
+		data := make([]byte, length)
+
+		for i := range data {
+			word := d.gdbWord(addr + i)
+
+			if word == nil {
+				return "E02", false
+			}
+
+			data[i] = byte(*word >> (8 * uint((addr+i)%2)))
+		}
+
+		return hex.EncodeToString(data), false
+
+	case 'M':
+		parts := strings.SplitN(args, ":", 2)
+		addr, length, err := parseGDBRange(parts[0])
+
+		if err != nil || len(parts) != 2 || length > GDB_MAX_MEMORY {
+			return "E01", false
+		}
+
+		data, err := hex.DecodeString(parts[1])
+
+		if err != nil || len(data) != length {
+			return "E01", false
+		}
+
+		for i, b := range data {
+			word := d.gdbWord(addr + i)
+
+			if word == nil {
+				return "E02", false
+			}
+
+			shift := 8 * uint((addr+i)%2)
+			*word = *word&^(0xFF<<shift) | uint16(b)<<shift
+		}
+
+		return "OK", false
+
+	case 'Z', 'z':
+		return d.gdbBreakpoint(packet[0] == 'Z', args), false
+
+	case 's':
+		return gdbStop(d.step(1)), false
+
+	case 'c':
+		return gdbStop(d.resume(func() bool { return false })), false
+
+	case 'k':
+		return "OK", true
+
+	case 'D':
+		return "OK", true
+
+	case 'H', 'T':
+		return "OK", false
+
+	case 'q', 'Q':
+		return d.gdbQuery(packet), false
+	}
+
+	return "", false
+}
+
+// gdbQuery answers general queries, unknown ones get an empty reply
+func (d *debugger) gdbQuery(packet string) string {
+	name := strings.SplitN(packet, ":", 2)[0]
+
+	switch name {
+	case "qSupported":
+		return fmt.Sprintf("PacketSize=%x;QStartNoAckMode+;qXfer:features:read+;swbreak+", GDB_PACKET_SIZE)
+	case "QStartNoAckMode":
+		return "OK"
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC1"
+	case "qfThreadInfo":
+		return "m1"
+	case "qsThreadInfo":
+		return "l"
+	case "qXfer":
+		const prefix = "qXfer:features:read:target.xml:"
+
+		if !strings.HasPrefix(packet, prefix) {
+			return ""
+		}
+
+		offset, length, err := parseGDBRange(packet[len(prefix):])
+
+		if err != nil {
+			return "E01"
+		}
+
+		if offset >= len(GDB_TARGET) {
+			return "l"
+		}
+
+		if end := offset + length; end < len(GDB_TARGET) {
+			return "m" + GDB_TARGET[offset:end]
+		}
+
+		return "l" + GDB_TARGET[offset:]
+	}
+
+	return ""
+}
+
+// gdbBreakpoint inserts or removes a breakpoint (types 0 and 1) or a
+// write watchpoint (type 2) covering every word in its range
+func (d *debugger) gdbBreakpoint(insert bool, args string) string {
+	parts := strings.Split(args, ",")
+
+	if len(parts) < 3 {
+		return "E01"
+	}
+
+	addr, length, err := parseGDBRange(parts[1] + "," + parts[2])
+
+	if err != nil {
+		return "E01"
+	}
+
+	var watch bool
+	var words []uint16
+
+	switch parts[0] {
+	case "0", "1":
+		if addr >= GDB_RAM {
+			return "E01"
+		}
+		words = []uint16{uint16(addr / 2)}
+	case "2":
+		if addr < GDB_RAM || addr+length > GDB_RAM+2*RAM_SIZE {
+			return "E01"
+		}
+
+		watch = true
+
+		for a := addr - addr%2; a < addr+length; a += 2 {
+			words = append(words, uint16((a-GDB_RAM)/2))
+		}
+	default:
+		return ""
+	}
+
+	for _, word := range words {
+		found := false
+
+		for _, b := range d.breakpoints {
+			if b.Addr == word && b.Watch == watch {
+				if !insert {
+					d.remove(b.ID)
+				}
+				found = true
+				break
+			}
+		}
+
+		if insert && !found {
+			d.breakAt(word, watch)
+		}
+	}
+
+	return "OK"
+}
+
+// gdbStop returns the stop reply: the halted program exits, watchpoints
+// report the byte address written, an exhausted cycle limit raises
+// SIGXCPU and an interrupt SIGINT
+func gdbStop(s stop) string {
+	switch s.Reason {
+	case STOP_HALT:
+		return "W00"
+	case STOP_WATCHPOINT:
+		return fmt.Sprintf("T%02xwatch:%x;", SIGTRAP, GDB_RAM+2*int(s.Addr))
+	case STOP_BREAKPOINT:
+		return fmt.Sprintf("T%02xswbreak:;", SIGTRAP)
+	case STOP_LIMIT:
+		return gdbSignal(SIGXCPU)
+	case STOP_INTERRUPT:
+		return gdbSignal(SIGINT)
+	}
+
+	return gdbSignal(SIGTRAP)
+}
+
+func gdbSignal(sig int) string {
+	return fmt.Sprintf("S%02x", sig)
+}
+
+// gdbWord returns the word holding the byte at a GDB address, nil
+// outside of ROM and RAM
+func (d *debugger) gdbWord(addr int) *uint16 {
+	switch {
+	case addr >= 0 && addr < 2*ROM_SIZE:
+		return &d.cpu.ROM[addr/2]
+	case addr >= GDB_RAM && addr < GDB_RAM+2*RAM_SIZE:
+		return &d.cpu.RAM[(addr-GDB_RAM)/2]
+	}
+	return nil
+}
+
+// gdbWords encodes words as little-endian hex
+func gdbWords(words ...uint16) string {
+	var buf strings.Builder
+
+	for _, w := range words {
+		fmt.Fprintf(&buf, "%02x%02x", w&0xFF, w>>8)
+	}
+
+	return buf.String()
+}
+
+func parseGDBWords(str string) ([]uint16, error) {
+	data, err := hex.DecodeString(str)
+
+	if err != nil || len(data)%2 != 0 {
+		return nil, fmt.Errorf("bad register data \"%s\"", str)
+	}
+
+	words := make([]uint16, len(data)/2)
+
+	for i := range words {
+		words[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+
+	return words, nil
+}
+
+// parseGDBRange parses "ADDR,LENGTH" in hex
+func parseGDBRange(str string) (addr, length int, err error) {
+	parts := strings.Split(str, ",")
+
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("bad range \"%s\"", str)
+	}
+
+	a, err := strconv.ParseUint(parts[0], 16, 32)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, err := strconv.ParseUint(parts[1], 16, 32)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(a), int(n), nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+// gdbClient is a scripted GDB client connected to a stub on localhost
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	done chan error
+}
+
+func startGDB(t *testing.T, src string) *gdbClient {
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newDebugger(prog.Code, prog.RAM(), prog.Symbols, programSource(prog))
+	d.cycles = math.MaxInt32
+	done := make(chan error, 1)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+
+		if err != nil {
+			done <- err
+			return
+		}
+
+		defer conn.Close()
+		done <- d.serveGDB(conn)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	return &gdbClient{t, conn, bufio.NewReader(conn), done}
+}
+
+// ack reads the acknowledgment of a packet
+func (c *gdbClient) ack() byte {
+	ack, err := c.r.ReadByte()
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return ack
+}
+
+// packet sends a packet and returns the reply
+func (c *gdbClient) packet(data string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", data, checksum(data))
+
+	if ack := c.ack(); ack != '+' {
+		c.t.Fatalf("Expected + for %s, have %c", data, ack)
+	}
+
+	return c.reply()
+}
+
+func (c *gdbClient) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+
+	reply, err := c.r.ReadString('#')
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	sum := make([]byte, 2)
+
+	if _, err = io.ReadFull(c.r, sum); err != nil {
+		c.t.Fatal(err)
+	}
+
+	reply = reply[:len(reply)-1]
+
+	if string(sum) != fmt.Sprintf("%02x", checksum(reply)) {
+		c.t.Errorf("Bad checksum %s of %s", sum, reply)
+	}
+
+	c.conn.Write([]byte("+"))
+	return reply
+}
+
+func TestGDBSession(t *testing.T) {
+	c := startGDB(t, DEBUG_SRC)
+
+	// DEC is at 24, byte 0x30, and i at RAM 16, byte 0x10020
+	script := [][2]string{
+		{"qSupported:multiprocess+", "PacketSize=4000;QStartNoAckMode+;qXfer:features:read+;swbreak+"},
+		{"qXfer:features:read:target.xml:0,20", "m" + GDB_TARGET[:0x20]},
+		{"?", "S05"},
+		{"g", "000000000000"},
+		{"vMustReplyEmpty", ""},
+		{"Z0,30,2", "OK"},
+		{"c", "T05swbreak:;"},
+		{"g", "180003003000"},
+		{"m10020,2", "0300"},
+		{"m0,4", "000110ec"},
+		{"m2000000,2", "E02"},
+		{"M10020,2001:00", "E01"},
+		{"M10020,2:0100", "OK"},
+		{"z0,30,2", "OK"},
+		{"Z2,10020,2", "OK"},
+		{"c", "T05watch:10020;"},
+		{"p2", "3400"},
+		{"P1=0500", "OK"},
+		{"p1", "0500"},
+		{"G010002003400", "OK"},
+		{"g", "010002003400"},
+		{"s", "S05"},
+		{"p2", "3600"},
+		{"z2,10020,2", "OK"},
+		{"c", "W00"},
+		{"k", "OK"},
+	}
+
+	for _, step := range script {
+		if have := c.packet(step[0]); have != step[1] {
+			t.Errorf("Expected %s to reply %q, have %q", step[0], step[1], have)
+		}
+	}
+
+	if err := <-c.done; err != nil {
+		t.Errorf("Expected the session to end, have %v", err)
+	}
+}
+
+func TestGDBProtocol(t *testing.T) {
+	c := startGDB(t, ".var i\n(LOOP)\n@i\nM=M+1\n@LOOP\n0;JMP\n")
+
+	if have := c.packet("m0,7fffffff"); len(have) != 2*GDB_MAX_MEMORY || !strings.HasPrefix(have, "1000") {
+		t.Errorf("Expected %d bytes of memory, have %d", GDB_MAX_MEMORY, len(have)/2)
+	}
+
+	fmt.Fprint(c.conn, "$g#00")
+
+	if ack := c.ack(); ack != '-' {
+		t.Errorf("Expected - for a bad checksum, have %c", ack)
+	}
+
+	fmt.Fprintf(c.conn, "$%s#00", strings.Repeat("g", GDB_PACKET_SIZE+1))
+
+	if ack := c.ack(); ack != '-' {
+		t.Errorf("Expected - for a packet longer than %d, have %c", GDB_PACKET_SIZE, ack)
+	}
+
+	if have := c.packet("QStartNoAckMode"); have != "OK" {
+		t.Errorf("Expected OK, have %q", have)
+	}
+
+	// without acknowledgments the reply follows the packet directly
+	fmt.Fprintf(c.conn, "$c#%02x", checksum("c"))
+	c.conn.Write([]byte{GDB_INTERRUPT})
+
+	if have := c.reply(); have != "S02" {
+		t.Errorf("Expected S02 after an interrupt, have %q", have)
+	}
+
+	fmt.Fprintf(c.conn, "$D#%02x", checksum("D"))
+
+	if have := c.reply(); have != "OK" {
+		t.Errorf("Expected OK, have %q", have)
+	}
+
+	if err := <-c.done; err != nil {
+		t.Errorf("Expected the session to end, have %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package hack
+
+import "sort"
+
+const (
+	E_FALLTHROUGH = iota
+	E_JUMP
+	E_BRANCH
+)
+
+// Edge leads to the block with index To, Kind is one of the E_ constants:
+// falling through to the next block, an unconditional jump or the taken
+// branch of a conditional jump
+type Edge struct {
+	To   int
+	Kind int
+}
+
+// Block is a basic block, the instructions at ROM addresses Start up to
+// End which only the first is jumped to and only the last jumps from
+type Block struct {
+	Start, End uint16
+	// Labels point to Start
+	Labels []string
+	Edges  []Edge
+	// Target is the index of the A-instruction loading the address the
+	// block jumps to, -1 when it doesn't jump or the address is computed
+	Target int
+	// Exit is set when the block falls through past the end of the code
+	Exit bool
+}
+
+// CFG is the control flow graph of a program, its entry is Blocks[0]
+type CFG struct {
+	Prog   *Program
+	Blocks []Block
+}
+
+// writesA reports whether the instruction changes A
+func writesA(tokens []Token) bool {
+	if tokens[0].Type == T_AINST {
+		return true
+	}
+
+	dest, _, _ := cInstruction(tokens)
+
+	for _, ch := range dest {
+		if ch == A_REG {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewCFG splits the code of prog into basic blocks at labels and jumps
+func NewCFG(prog *Program) *CFG {
+	g := &CFG{Prog: prog}
+
+	if len(prog.Lines) == 0 {
+		return g
+	}
+
+	labels := map[uint16][]string{}
+
+	for name, symbol := range prog.Symbols {
+		if symbol.Kind == S_LABEL && int(symbol.Addr) < len(prog.Lines) {
+			labels[symbol.Addr] = append(labels[symbol.Addr], name)
+		}
+	}
+
+	start := 0
+
+	for i, line := range prog.Lines {
+		_, _, jmp := cInstruction(line.Tokens)
+		next := i + 1
+
+		if jmp == "" && next < len(prog.Lines) && len(labels[uint16(next)]) == 0 {
+			continue
+		}
+
+		block := Block{Start: uint16(start), End: uint16(next), Target: -1}
+		block.Labels = labels[block.Start]
+		sort.Strings(block.Labels)
+
+		if jmp != "" {
+			for k := i - 1; k >= start; k-- {
+				if writesA(prog.Lines[k].Tokens) {
+					if prog.Lines[k].Tokens[0].Type == T_AINST {
+						block.Target = k
+					}
+					break
+				}
+			}
+		}
+
+		g.Blocks = append(g.Blocks, block)
+		start = next
+	}
+
+	for i := range g.Blocks {
+		g.link(i)
+	}
+
+	return g
+}
+
+// BlockAt returns the index of the block containing addr, -1 when addr
+// is outside of the code
+func (g *CFG) BlockAt(addr int) int {
+	i := sort.Search(len(g.Blocks), func(i int) bool { return int(g.Blocks[i].End) > addr })
+
+	if i == len(g.Blocks) || addr < 0 {
+		return -1
+	}
+
+	return i
+}
+
+// link adds the edges leaving block i
+func (g *CFG) link(i int) {
+	b := &g.Blocks[i]
+	_, _, jmp := cInstruction(g.Prog.Lines[b.End-1].Tokens)
+
+	if jmp != "" && b.Target >= 0 && !g.loadsVariable(b.Target) {
+		kind := E_BRANCH
+
+		if jmp == JMP {
+			kind = E_JUMP
+		}
+
+		if to := g.BlockAt(int(g.Prog.Code[b.Target])); to >= 0 {
+			b.Edges = append(b.Edges, Edge{to, kind})
+		}
+	}
+
+	if jmp == JMP {
+		return
+	}
+
+	if i+1 < len(g.Blocks) {
+		b.Edges = append(b.Edges, Edge{i + 1, E_FALLTHROUGH})
+	} else {
+		b.Exit = true
+	}
+}
+
+// loadsVariable reports whether the A-instruction at i loads the
+// address of a variable, which is no place in ROM to jump to
+func (g *CFG) loadsVariable(i int) (found bool) {
+	t := g.Prog.Lines[i].Tokens[0]
+
+	scanSymbols(t.Val, func(start, end int) {
+		if g.Prog.Symbols[t.Val[start:end]].Kind == S_VARIABLE {
+			found = true
+		}
+	})
+
+	return
+}
+
+// Indirect reports whether block i jumps to a computed address
+func (g *CFG) Indirect(i int) bool {
+	b := g.Blocks[i]
+	_, _, jmp := cInstruction(g.Prog.Lines[b.End-1].Tokens)
+
+	return jmp != "" && b.Target < 0
+}
+
+// Reachable marks the blocks reachable from the entry. Indirect jumps
+// reach every block whose label address is loaded by an A-instruction.
+func (g *CFG) Reachable() []bool {
+	reached := make([]bool, len(g.Blocks))
+
+	if len(g.Blocks) == 0 {
+		return reached
+	}
+
+	var taken []int
+
+	for _, line := range g.Prog.Lines {
+		if t := line.Tokens[0]; t.Type == T_AINST {
+			scanSymbols(t.Val, func(start, end int) {
+				if symbol, ok := g.Prog.Symbols[t.Val[start:end]]; ok && symbol.Kind == S_LABEL {
+					if i := g.BlockAt(int(symbol.Addr)); i >= 0 {
+						taken = append(taken, i)
+					}
+				}
+			})
+		}
+	}
+
+	queue := []int{0}
+	reached[0] = true
+	indirect := false
+
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+
+		next := append([]Edge(nil), g.Blocks[i].Edges...)
+
+		if g.Indirect(i) && !indirect {
+			indirect = true
+
+			for _, to := range taken {
+				next = append(next, Edge{to, E_JUMP})
+			}
+		}
+
+		for _, e := range next {
+			if !reached[e.To] {
+				reached[e.To] = true
+				queue = append(queue, e.To)
+			}
+		}
+	}
+
+	return reached
+}
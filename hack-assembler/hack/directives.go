@@ -0,0 +1,243 @@
+package hack
+
+import "strings"
+
+const DIRECTIVE = '.'
+
+// directiveArgs holds the minimum and maximum number of arguments of
+// every directive, -1 means any number
+var directiveArgs = map[string][2]int{
+	".equ":     {2, 2},
+	".var":     {1, 2},
+	".data":    {2, -1},
+	".macro":   {1, -1},
+	".endm":    {0, 0},
+	".include": {1, 1},
+	".export":  {1, -1},
+	".import":  {1, -1},
+}
+
+var directiveUsage = map[string]string{
+	".equ":     ".equ NAME VALUE",
+	".var":     ".var NAME [SIZE]",
+	".data":    ".data ADDRESS VALUE...",
+	".macro":   ".macro NAME [PARAM...]",
+	".endm":    ".endm",
+	".include": ".include \"FILE\"",
+	".export":  ".export NAME...",
+	".import":  ".import NAME...",
+}
+
+// Data is a block of words stored in RAM before the program starts
+type Data struct {
+	Addr  uint16
+	Words []uint16
+}
+
+func isDirective(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), string(DIRECTIVE))
+}
+
+// parseDirective splits a directive line into a T_DIRECTIVE token and
+// T_ARG tokens. Arguments are separated by whitespace or commas, so
+// expressions in arguments can't contain spaces.
+func parseDirective(line string) ([]Token, error) {
+	tokens := splitFields(line)
+	tokens[0].Type = T_DIRECTIVE
+
+	name := tokens[0]
+	limits, ok := directiveArgs[name.Val]
+
+	if !ok {
+		return nil, errorf(name.Col(0), "unknown directive \"%s\"", name.Val)
+	}
+
+	if n := len(tokens) - 1; n < limits[0] || (limits[1] >= 0 && n > limits[1]) {
+		return nil, errorf(name.Col(0), "expected %s", directiveUsage[name.Val])
+	}
+
+	args := tokens[1:]
+
+	switch name.Val {
+	case ".include":
+		if arg := args[0].Val; len(arg) < 3 || arg[0] != '"' || arg[len(arg)-1] != '"' {
+			return nil, errorf(args[0].Col(0), "expected %s", directiveUsage[name.Val])
+		}
+		args = nil
+	case ".data", ".endm":
+		args = nil
+	case ".equ", ".var":
+		args = args[:1]
+	}
+
+	for _, t := range args {
+		if !isSymbol(t.Val) {
+			return nil, errorf(t.Col(0), "invalid symbol \"%s\"", t.Val)
+		}
+	}
+
+	return tokens, nil
+}
+
+// splitFields splits line into T_ARG tokens separated by whitespace or
+// commas, characters in single or double quotes are never separators
+func splitFields(line string) (tokens []Token) {
+	for i := 0; i < len(line); {
+		if strings.IndexByte(" \t\r,", line[i]) >= 0 {
+			i++
+			continue
+		}
+
+		start := i
+		var quote byte
+
+		for i < len(line) && (quote != 0 || strings.IndexByte(" \t\r,", line[i]) < 0) {
+			switch {
+			case quote == 0 && (line[i] == '\'' || line[i] == '"'):
+				quote = line[i]
+			case line[i] == quote:
+				quote = 0
+			}
+			i++
+		}
+
+		cols := make([]int, i-start)
+		for j := range cols {
+			cols[j] = start + j + 1
+		}
+
+		tokens = append(tokens, Token{T_ARG, line[start:i], cols})
+	}
+
+	return
+}
+
+// defineDirectives executes directives in source order once labels are
+// known. Constants, variables and imports are added to symbols, initial
+// RAM data is returned.
+func defineDirectives(directives []Line, symbols SymbolTable) (data []Data, errs ErrorList) {
+	initialized := map[int]bool{}
+
+	for _, line := range directives {
+		tokens := line.Tokens
+
+		switch tokens[0].Val {
+		case ".equ":
+			value, err := evalConst(tokens[2], symbols, 0, MAX_VALUE)
+
+			if err == nil {
+				err = define(tokens[1], Symbol{uint16(value), S_CONSTANT}, symbols)
+			}
+
+			if err != nil {
+				errs.add(line, err)
+			}
+
+		case ".var":
+			size := 1
+
+			if len(tokens) > 2 {
+				var err error
+
+				if size, err = evalConst(tokens[2], symbols, 1, MAX_VALUE); err != nil {
+					errs.add(line, err)
+					continue
+				}
+			}
+
+			next := symbols[VAR]
+
+			if int(next.Addr)+size-1 > MAX_VALUE {
+				errs.add(line, errorf(tokens[len(tokens)-1].Col(0), "no room for %d words at %d", size, next.Addr))
+				continue
+			}
+
+			if err := define(tokens[1], Symbol{next.Addr, S_VARIABLE}, symbols); err != nil {
+				errs.add(line, err)
+				continue
+			}
+
+			symbols[VAR] = Symbol{next.Addr + uint16(size), next.Kind}
+
+		case ".data":
+			addr, err := evalConst(tokens[1], symbols, 0, MAX_VALUE)
+
+			if err != nil {
+				errs.add(line, err)
+				continue
+			}
+
+			block := Data{Addr: uint16(addr)}
+
+			for i, t := range tokens[2:] {
+				word, err := evalConst(t, symbols, -1<<15, 1<<16-1)
+
+				if err == nil && addr+i > MAX_VALUE {
+					err = errorf(t.Col(0), "data beyond RAM address %d", MAX_VALUE)
+				}
+
+				if err == nil && initialized[addr+i] {
+					err = errorf(t.Col(0), "RAM[%d] is already initialized", addr+i)
+				}
+
+				if err != nil {
+					errs.add(line, err)
+					break
+				}
+
+				initialized[addr+i] = true
+				block.Words = append(block.Words, uint16(word))
+			}
+
+			data = append(data, block)
+
+		case ".import":
+			for _, t := range tokens[1:] {
+				if err := define(t, Symbol{0, S_IMPORT}, symbols); err != nil {
+					errs.add(line, err)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// define adds a symbol named by t, which must not be defined yet
+func define(t Token, symbol Symbol, symbols SymbolTable) error {
+	if _, ok := symbols[t.Val]; ok {
+		return errorf(t.Col(0), "symbol \"%s\" already defined", t.Val)
+	}
+
+	symbols[t.Val] = symbol
+	return nil
+}
+
+// evalConst evaluates an expression of defined symbols and checks that
+// its value is in the range min..max
+func evalConst(t Token, symbols SymbolTable, min, max int) (int, error) {
+	p := &exprParser{t: t, symbols: symbols, strict: true}
+	value, err := p.parse()
+
+	if err == nil && (value < min || value > max) {
+		err = errorf(t.Col(0), "value %d of \"%s\" out of range %d..%d", value, t.Val, min, max)
+	}
+
+	return value, err
+}
+
+// RAM returns the initial RAM contents from address 0 up to the last
+// initialized word
+func (p *Program) RAM() []uint16 {
+	var ram []uint16
+
+	for _, block := range p.Data {
+		if end := int(block.Addr) + len(block.Words); end > len(ram) {
+			ram = append(ram, make([]uint16, end-len(ram))...)
+		}
+
+		copy(ram[block.Addr:], block.Words)
+	}
+
+	return ram
+}
@@ -0,0 +1,102 @@
+package hack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDirective(t *testing.T) {
+	tokens, err := ParseLine("  .data  table 1, 'A' -2 // init")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Token{
+		{T_DIRECTIVE, ".data", []int{3, 4, 5, 6, 7}},
+		{T_ARG, "table", []int{10, 11, 12, 13, 14}},
+		{T_ARG, "1", []int{16}},
+		{T_ARG, "'A'", []int{19, 20, 21}},
+		{T_ARG, "-2", []int{23, 24}},
+	}
+
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected %v, have %v", expected, tokens)
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	src := `
+.equ WIDTH 32
+.equ SIZE WIDTH*2
+.var first
+.var buf SIZE
+.data buf 1 2 'A'
+.data SCREEN-1 0xFFFF
+(START)
+.equ END_OF_START START+1
+@WIDTH
+@buf
+@i
+@END_OF_START
+`
+
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []uint16{32, 17, 81, 1}; !reflect.DeepEqual(prog.Code, expected) {
+		t.Errorf("Expected %v, have %v", expected, prog.Code)
+	}
+
+	switch {
+	case prog.Symbols["WIDTH"] != (Symbol{32, S_CONSTANT}):
+		t.Errorf("WIDTH should be a constant, have %v", prog.Symbols["WIDTH"])
+	case prog.Symbols["first"] != (Symbol{16, S_VARIABLE}):
+		t.Errorf("first should be a variable at 16, have %v", prog.Symbols["first"])
+	case prog.Symbols["i"] != (Symbol{81, S_VARIABLE}):
+		t.Errorf("i should follow buf, have %v", prog.Symbols["i"])
+	}
+
+	ram := prog.RAM()
+
+	switch {
+	case len(ram) != 0x4000:
+		t.Errorf("RAM image should end at SCREEN-1, have %d words", len(ram))
+	case ram[17] != 1 || ram[18] != 2 || ram[19] != 'A' || ram[20] != 0:
+		t.Errorf("Unexpected buf contents %v", ram[16:21])
+	case ram[0x3FFF] != 0xFFFF:
+		t.Errorf("Unexpected RAM[SCREEN-1] %d", ram[0x3FFF])
+	}
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	examples := map[string]Error{
+		".org 100":               {Column: 1, Msg: "unknown directive \".org\""},
+		".equ X":                 {Column: 1, Msg: "expected .equ NAME VALUE"},
+		".var 1x":                {Column: 6, Msg: "invalid symbol \"1x\""},
+		".equ X Y+1":             {Column: 8, Msg: "undefined symbol \"Y\""},
+		".equ SCREEN 1":          {Column: 6, Msg: "symbol \"SCREEN\" already defined"},
+		".equ X 40000":           {Column: 8, Msg: "value 40000 of \"40000\" out of range 0..32767"},
+		".var buf 0":             {Column: 10, Msg: "value 0 of \"0\" out of range 1..32767"},
+		".data 0 1 70000":        {Column: 11, Msg: "value 70000 of \"70000\" out of range -32768..65535"},
+		".data 32767 1 2":        {Column: 15, Msg: "data beyond RAM address 32767"},
+		".data 5 1\n.data 4 1 2": {Column: 11, Msg: "RAM[5] is already initialized"},
+	}
+
+	for src, expected := range examples {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, have %v", src, errs)
+			continue
+		}
+
+		if errs[0].Column != expected.Column || errs[0].Msg != expected.Msg {
+			t.Errorf("%q: expected %d: %s, have %d: %s", src, expected.Column, expected.Msg, errs[0].Column, errs[0].Msg)
+		}
+	}
+}
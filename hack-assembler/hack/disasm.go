@@ -0,0 +1,78 @@
+package hack
+
+import "fmt"
+
+const COMP_MASK = A_COMP | ZX | NX | ZY | NY | F | NO
+
+var compNames = map[uint16]string{}
+
+func init() {
+	for _, comp := range legalComps {
+		mask, _ := compileComp(Token{Type: T_COMP, Val: comp})
+		compNames[mask] = comp
+	}
+}
+
+func disassembleDest(i uint16) (dest string) {
+	if i&A_DEST != 0 {
+		dest += string(A_REG)
+	}
+	if i&M_DEST != 0 {
+		dest += string(M_REG)
+	}
+	if i&D_DEST != 0 {
+		dest += string(D_REG)
+	}
+	return
+}
+
+// DisassembleWord decodes one instruction into its canonical assembly form
+func DisassembleWord(i uint16) (string, error) {
+	if i&A_INST_MASK == 0 {
+		return fmt.Sprintf("%s%d", A, i), nil
+	}
+
+	if i&C_INST_MASK != C_INST_MASK {
+		return "", fmt.Errorf("C-instruction %016b has unset bits 13-14", i)
+	}
+
+	comp, ok := compNames[i&COMP_MASK]
+
+	if !ok {
+		return "", fmt.Errorf("C-instruction %016b has no legal comp", i)
+	}
+
+	line := comp
+
+	if dest := disassembleDest(i); dest != "" {
+		line = dest + "=" + line
+	}
+
+	if jmp := jmpNames[i&JMP_MASK]; jmp != "" {
+		line = line + ";" + jmp
+	}
+
+	return line, nil
+}
+
+// Disassemble decodes code into assembly lines, assembling them
+// reproduces code. The assembler can't encode words with unset bits 13-14
+// or without a legal comp, so when there are any their addresses are
+// returned as illegal and no lines.
+func Disassemble(code []uint16) (lines []string, illegal []int) {
+	for addr, i := range code {
+		line, err := DisassembleWord(i)
+
+		if err != nil {
+			illegal = append(illegal, addr)
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(illegal) > 0 {
+		return nil, illegal
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,82 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassembleWord(t *testing.T) {
+	examples := map[string]string{
+		"@17":         "@17",
+		"@32767":      "@32767",
+		"D=A":         "D=A",
+		"DM=M+1":      "MD=M+1",
+		"AMD=D|M;JLE": "AMD=D|M;JLE",
+		"0;JMP":       "0;JMP",
+		"MA=!D":       "AM=!D",
+		"D;JGT":       "D;JGT",
+		"A=-1":        "A=-1",
+		"DA=A-D;JNE":  "AD=A-D;JNE",
+		"M=D&M":       "M=D&M",
+		"MD=-M;JGE":   "MD=-M;JGE",
+		"AD=D-1;JEQ":  "AD=D-1;JEQ",
+		"AMD=D+A;JLT": "AMD=D+A;JLT",
+	}
+
+	for src, expected := range examples {
+		code := mustCompile(t, src)
+		res, err := DisassembleWord(code[0])
+
+		if err != nil {
+			t.Errorf("Can't disassemble \"%s\": %v", src, err)
+		} else if res != expected {
+			t.Errorf("\"%s\" should be disassembled as \"%s\", but have \"%s\"", src, expected, res)
+		}
+	}
+}
+
+func TestDisassembleIllegal(t *testing.T) {
+	for _, i := range []uint16{C_INST_MASK | ZX, 1 << 15, C_INST_MASK | A_COMP | ZX | ZY | F} {
+		if _, err := DisassembleWord(i); err == nil {
+			t.Errorf("%016b should not be disassembled", i)
+		}
+	}
+
+	lines, illegal := Disassemble([]uint16{0, C_INST_MASK | ZX, 1, 1 << 15})
+
+	if len(illegal) != 2 || illegal[0] != 1 || illegal[1] != 3 {
+		t.Errorf("Words 1 and 3 should be reported as illegal, have %v", illegal)
+	}
+
+	if lines != nil {
+		t.Errorf("Expected no lines for code with illegal words, have %v", lines)
+	}
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	var code []uint16
+
+	for i := 0; i < 1<<16; i++ {
+		if _, err := DisassembleWord(uint16(i)); err == nil {
+			code = append(code, uint16(i))
+		}
+	}
+
+	lines, illegal := Disassemble(code)
+
+	if len(illegal) != 0 {
+		t.Fatalf("Unexpected illegal words: %v", illegal)
+	}
+
+	res := mustCompile(t, strings.Join(lines, "\n"))
+
+	if len(res) != len(code) {
+		t.Fatalf("Expected %d instructions, have %d", len(code), len(res))
+	}
+
+	for i := range code {
+		if res[i] != code[i] {
+			t.Errorf("\"%s\" compiled to %016b, should be %016b", lines[i], res[i], code[i])
+		}
+	}
+}
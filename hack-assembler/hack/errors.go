@@ -0,0 +1,114 @@
+package hack
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Error describes a problem found in assembly source. Errors in lines
+// expanded from macros also refer to the macro call.
+type Error struct {
+	File      string
+	Line      int
+	Column    int
+	Source    string
+	Msg       string
+	Expansion *Expansion
+}
+
+func errorf(col int, format string, args ...interface{}) *Error {
+	return &Error{Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	pos := e.File
+
+	if pos == "" {
+		pos = "-"
+	}
+
+	if e.Line > 0 {
+		pos = fmt.Sprintf("%s:%d", pos, e.Line)
+
+		if e.Column > 0 {
+			pos = fmt.Sprintf("%s:%d", pos, e.Column)
+		}
+	}
+
+	msg := fmt.Sprintf("%s: %s", pos, e.Msg)
+
+	for x := e.Expansion; x != nil; x = x.Parent {
+		sep := ", "
+		if x == e.Expansion {
+			sep = " ("
+		}
+
+		if x.Macro == "" {
+			msg += fmt.Sprintf("%sincluded from %s:%d", sep, x.File, x.Line)
+		} else {
+			msg += fmt.Sprintf("%sexpanded from %s at %s:%d", sep, x.Macro, x.File, x.Line)
+		}
+	}
+
+	if e.Expansion != nil {
+		msg += ")"
+	}
+
+	return msg
+}
+
+// ErrorList collects all errors found in a single pass over the source
+type ErrorList []*Error
+
+func (l *ErrorList) add(line Line, err error) {
+	e, ok := err.(*Error)
+
+	if !ok {
+		e = &Error{Msg: err.Error()}
+	}
+
+	e.File, e.Line, e.Source, e.Expansion = line.File, line.Num, line.Src, line.Expansion
+	*l = append(*l, e)
+}
+
+func (l ErrorList) Len() int      { return len(l) }
+func (l ErrorList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l ErrorList) Less(i, j int) bool {
+	a, b := l[i], l[j]
+
+	switch {
+	case a.File != b.File:
+		return a.File < b.File
+	case a.Line != b.Line:
+		return a.Line < b.Line
+	case a.Column != b.Column:
+		return a.Column < b.Column
+	default:
+		return a.Msg < b.Msg
+	}
+}
+
+// Sort orders the list by file, line, column and message
+func (l ErrorList) Sort() {
+	sort.Sort(l)
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+	}
+}
+
+// Err returns nil for an empty list, so it can be returned as error
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
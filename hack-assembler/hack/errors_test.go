@@ -0,0 +1,73 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func compileErrors(t *testing.T, src string) ErrorList {
+	_, err := New("test.asm").Assemble(strings.NewReader(src))
+
+	errs, ok := err.(ErrorList)
+
+	if !ok {
+		t.Fatalf("Expected ErrorList, have %v", err)
+	}
+
+	return errs
+}
+
+func TestErrorString(t *testing.T) {
+	err := &Error{File: "file.asm", Line: 12, Column: 3, Msg: "unknown register 'X'"}
+
+	if err.Error() != "file.asm:12:3: unknown register 'X'" {
+		t.Errorf("Unexpected error string: %s", err)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	examples := map[string]Error{
+		"  X=D":           {Line: 1, Column: 3, Msg: "unknown register 'X'"},
+		"\tAA=D":          {Line: 1, Column: 2, Msg: "duplicated dest register 'A'"},
+		"D = D + X":       {Line: 1, Column: 9, Msg: "unknown register 'X'"},
+		"D=!X":            {Line: 1, Column: 4, Msg: "unknown operand 'X'"},
+		"@1\nD=A\n D=A=M": {Line: 3, Column: 5, Msg: "only one \"=\" allowed"},
+		"D=":              {Line: 1, Column: 3, Msg: "missing comp"},
+		"@":               {Line: 1, Column: 1, Msg: "missing A-instruction value"},
+		"@40000":          {Line: 1, Column: 2, Msg: "address 40000 out of range 0..32767"},
+		"(LOOP":           {Line: 1, Column: 6, Msg: "missing \")\""},
+		"@a#b":            {Line: 1, Column: 3, Msg: "unexpected '#'"},
+		"D=A+M":           {Line: 1, Column: 3, Msg: "can't operate on A and M simultaneously"},
+	}
+
+	for src, expected := range examples {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, have %v", src, errs)
+			continue
+		}
+
+		e := errs[0]
+		expected.File = "test.asm"
+		expected.Source = strings.Split(src, "\n")[expected.Line-1]
+
+		if *e != expected {
+			t.Errorf("%q: expected %#v, have %#v", src, expected, *e)
+		}
+	}
+}
+
+func TestCollectAllErrors(t *testing.T) {
+	errs := compileErrors(t, "X=D\n@1\nD=Q\n(END\n@END\n0;JMP")
+
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, have %d: %v", len(errs), errs)
+	}
+
+	for i, line := range []int{1, 3, 4} {
+		if errs[i].Line != line {
+			t.Errorf("Error %d should be on line %d, have %d", i, line, errs[i].Line)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package hack
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MAX_VALUE is the largest value an A-instruction can load
+const MAX_VALUE = 1<<15 - 1
+
+// MAX_EXPR bounds the numbers and the values computed on the way to the
+// value of an expression, so they can't overflow
+const MAX_EXPR = 1<<32 - 1
+
+// exprParser evaluates the constant expression of an A-instruction:
+// decimal, 0x hexadecimal and 0b binary numbers, 'c' characters and
+// symbols combined with + - * / and parentheses. Undefined symbols are
+// errors when strict is set.
+type exprParser struct {
+	t       Token
+	pos     int
+	symbols SymbolTable
+	strict  bool
+}
+
+// evalExpr evaluates the value of an A-instruction token, undefined
+// symbols are allocated as variables
+func evalExpr(t Token, symbols SymbolTable) (int, error) {
+	p := &exprParser{t: t, symbols: symbols}
+	return p.parse()
+}
+
+func (p *exprParser) parse() (int, error) {
+	value, err := p.expr()
+
+	if err == nil && p.pos < len(p.t.Val) {
+		err = p.errorf("unexpected '%c'", p.t.Val[p.pos])
+	}
+
+	return value, err
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) *Error {
+	return errorf(p.t.Col(p.pos), format, args...)
+}
+
+func (p *exprParser) peek() byte {
+	if p.pos < len(p.t.Val) {
+		return p.t.Val[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) expr() (int, error) {
+	value, err := p.term()
+
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op, pos := p.peek(), p.pos
+		p.pos++
+
+		var right int
+
+		if right, err = p.term(); err != nil {
+			break
+		}
+
+		if op == '+' {
+			value += right
+		} else {
+			value -= right
+		}
+
+		err = p.check(value, pos)
+	}
+
+	return value, err
+}
+
+func (p *exprParser) term() (int, error) {
+	value, err := p.unary()
+
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op, pos := p.peek(), p.pos
+		p.pos++
+
+		var right int
+
+		if right, err = p.unary(); err != nil {
+			break
+		}
+
+		switch {
+		case op == '*' && right != 0 && abs(value) > MAX_EXPR/abs(right):
+			p.pos = pos
+			err = p.errorf("overflow")
+		case op == '*':
+			value *= right
+		case right == 0:
+			p.pos = pos
+			err = p.errorf("division by zero")
+		default:
+			value /= right
+		}
+	}
+
+	return value, err
+}
+
+// check fails with the operator at pos when value is beyond MAX_EXPR
+func (p *exprParser) check(value, pos int) error {
+	if value < -MAX_EXPR || value > MAX_EXPR {
+		p.pos = pos
+		return p.errorf("overflow")
+	}
+	return nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func (p *exprParser) unary() (int, error) {
+	if p.peek() == '-' {
+		p.pos++
+		value, err := p.unary()
+		return -value, err
+	}
+
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int, error) {
+	start := p.pos
+	ch := p.peek()
+
+	switch {
+	case ch == '(':
+		p.pos++
+		value, err := p.expr()
+
+		if err != nil {
+			return 0, err
+		}
+
+		if p.peek() != ')' {
+			return 0, p.errorf("missing \")\"")
+		}
+
+		p.pos++
+		return value, nil
+
+	case ch == '\'':
+		end := strings.IndexByte(p.t.Val[start+1:], '\'')
+
+		if end != 1 {
+			return 0, p.errorf("invalid character literal")
+		}
+
+		p.pos += 3
+		return int(p.t.Val[start+1]), nil
+
+	case p.pos == len(p.t.Val):
+		return 0, p.errorf("missing value")
+
+	case isSymbolChar(rune(ch)):
+		for p.pos < len(p.t.Val) && isSymbolChar(rune(p.peek())) {
+			p.pos++
+		}
+
+		name := p.t.Val[start:p.pos]
+
+		if ch >= '0' && ch <= '9' {
+			return p.number(name)
+		}
+
+		if _, ok := p.symbols[name]; p.strict && !ok {
+			p.pos = start
+			return 0, p.errorf("undefined symbol \"%s\"", name)
+		}
+
+		return int(symbolToAddr(name, p.symbols)), nil
+
+	default:
+		return 0, p.errorf("unexpected '%c'", ch)
+	}
+}
+
+// scanSymbols calls fn with the start and end of every symbol in the
+// expression val, numbers and character literals are skipped
+func scanSymbols(val string, fn func(start, end int)) {
+	for i := 0; i < len(val); {
+		ch := val[i]
+
+		switch {
+		case ch == '\'':
+			i += 3
+			continue
+		case !isSymbolChar(rune(ch)):
+			i++
+			continue
+		}
+
+		start := i
+
+		for i < len(val) && isSymbolChar(rune(val[i])) {
+			i++
+		}
+
+		if ch < '0' || ch > '9' {
+			fn(start, i)
+		}
+	}
+}
+
+// ScanSymbols calls fn with the start and end of every symbol in the
+// source line src before its comment, as scanSymbols
+func ScanSymbols(src string, fn func(start, end int)) {
+	scanSymbols(stripComment(src), fn)
+}
+
+// number parses a decimal, 0x hexadecimal or 0b binary number ending
+// at the current position
+func (p *exprParser) number(str string) (int, error) {
+	digits, base := str, 10
+
+	switch {
+	case strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X"):
+		digits, base = str[2:], 16
+	case strings.HasPrefix(str, "0b") || strings.HasPrefix(str, "0B"):
+		digits, base = str[2:], 2
+	}
+
+	value, err := strconv.ParseUint(digits, base, 32)
+
+	if err != nil {
+		p.pos -= len(str)
+
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return 0, p.errorf("number %s out of range", str)
+		}
+		return 0, p.errorf("invalid number \"%s\"", str)
+	}
+
+	return int(value), nil
+}
@@ -0,0 +1,77 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAinstructionExpressions(t *testing.T) {
+	examples := map[string]uint16{
+		"@0x4000":           0x4000,
+		"@0X7fff":           0x7FFF,
+		"@0b1010":           10,
+		"@'A'":              65,
+		"@' '":              32,
+		"@SCREEN+512":       0x4200,
+		"@KBD/2":            0x3000,
+		"@LOOP-1":           1,
+		"@LOOP*2+1":         5,
+		"@(SCREEN+32)*2/4":  0x2010,
+		"@ SCREEN + 32 * 2": 0x4040,
+		"@-(-5)":            5,
+		"@i+1":              17,
+		"@010":              10,
+	}
+
+	for src, expected := range examples {
+		code := mustCompile(t, "@0\n@0\n(LOOP)\n"+src)
+
+		if code[2] != expected {
+			t.Errorf("%q should be %d, have %d", src, expected, code[2])
+		}
+	}
+}
+
+func TestAinstructionExpressionErrors(t *testing.T) {
+	examples := map[string]Error{
+		"@SCREEN*2":                         {Column: 2, Msg: "value 32768 of \"SCREEN*2\" out of range 0..32767"},
+		"@0-1":                              {Column: 2, Msg: "value -1 of \"0-1\" out of range 0..32767"},
+		"@KBD/(1-1)":                        {Column: 5, Msg: "division by zero"},
+		"@(1+2":                             {Column: 6, Msg: "missing \")\""},
+		"@1+":                               {Column: 4, Msg: "missing value"},
+		"@0x":                               {Column: 2, Msg: "invalid number \"0x\""},
+		"@0b102":                            {Column: 2, Msg: "invalid number \"0b102\""},
+		"@1abc":                             {Column: 2, Msg: "invalid number \"1abc\""},
+		"@'AB'":                             {Column: 2, Msg: "invalid character literal"},
+		"@1)":                               {Column: 3, Msg: "unexpected ')'"},
+		"@99999999999":                      {Column: 2, Msg: "number 99999999999 out of range"},
+		"@0xFFFFFFFF*0xFFFFFFFF*0xFFFFFFFF": {Column: 12, Msg: "overflow"},
+		"@-0xFFFF*0xFFFF-0xFFFF*0xFFFF":     {Column: 16, Msg: "overflow"},
+	}
+
+	for src, expected := range examples {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, have %v", src, errs)
+			continue
+		}
+
+		if errs[0].Column != expected.Column || errs[0].Msg != expected.Msg {
+			t.Errorf("%q: expected %d: %s, have %d: %s", src, expected.Column, expected.Msg, errs[0].Column, errs[0].Msg)
+		}
+	}
+}
+
+func TestExpressionAllocatesVariables(t *testing.T) {
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader("@buf+2\n@buf"))
+
+	switch {
+	case err != nil:
+		t.Fatal(err)
+	case prog.Code[0] != 18 || prog.Code[1] != 16:
+		t.Errorf("Expected variable buf at 16, have %v", prog.Code)
+	case prog.Symbols["buf"].Kind != S_VARIABLE:
+		t.Errorf("buf should be a variable")
+	}
+}
@@ -0,0 +1,286 @@
+package hack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const INDENT = "    "
+
+// fmtLine is a formatted source line, comment is the trailing comment or
+// the whole line when code is empty
+type fmtLine struct {
+	indent  int
+	code    string
+	comment string
+}
+
+// width returns the length of the indented code of the line
+func (l fmtLine) width() int {
+	return l.indent*len(INDENT) + len(l.code)
+}
+
+// commentOnly reports whether the line holds just a comment
+func (l fmtLine) commentOnly() bool {
+	return l.code == "" && l.comment != ""
+}
+
+// Format returns the source read from r in canonical layout. Labels,
+// directives and comments starting a line stay at the left margin,
+// instructions and macro calls are indented, one more level inside
+// macro definitions. Whitespace inside instructions is removed, dest
+// registers are ordered as AMD and operands of commutative comps as in
+// D+A. Trailing comments of consecutive lines are aligned, runs of blank
+// lines are collapsed. Formatting a formatted source changes nothing, a
+// source with syntax errors isn't formatted.
+func Format(name string, r io.Reader) ([]byte, error) {
+	var (
+		src   []string
+		lines []fmtLine
+		errs  ErrorList
+	)
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		src = append(src, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: can't read source: %v", name, err)
+	}
+
+	calls := map[string]bool{}
+
+	for name := range pseudoInstructions {
+		calls[name] = true
+	}
+
+	for _, line := range src {
+		if fields := splitFields(stripComment(line)); len(fields) > 1 && fields[0].Val == ".macro" {
+			calls[fields[1].Val] = true
+		}
+	}
+
+	depth := 0
+
+	for i, text := range src {
+		line := fmtLine{indent: depth}
+		code := stripComment(text)
+
+		if len(code) < len(text) {
+			line.comment = strings.TrimRight(text[len(code):], " \t\r")
+		}
+
+		fields := splitFields(code)
+
+		switch {
+		case strings.TrimSpace(code) == "":
+		case isDirective(code):
+			if _, err := ParseLine(code); err != nil {
+				errs.add(Line{File: name, Num: i + 1, Src: text}, err)
+			}
+
+			switch fields[0].Val {
+			case ".macro":
+				depth = 1
+			case ".endm":
+				line.indent, depth = 0, 0
+			}
+
+			line.code = joinFields(code, fields)
+		case len(fields) > 0 && isCall(fields, calls):
+			line.indent++
+			line.code = joinFields(code, fields)
+		default:
+			tokens, err := ParseLine(code)
+
+			if err != nil {
+				errs.add(Line{File: name, Num: i + 1, Src: text}, err)
+				continue
+			}
+
+			if tokens[0].Type != T_LABEL {
+				line.indent++
+			}
+
+			line.code = formatTokens(tokens)
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	indentComments(lines)
+	return writeFormatted(lines), nil
+}
+
+// isCall reports whether fields are a call of a macro or
+// pseudo-instruction. Calls of macros defined in other files are told
+// from C-instructions written with spaces by their second field, which
+// doesn't start with an operator.
+func isCall(fields []Token, calls map[string]bool) bool {
+	if calls[fields[0].Val] {
+		return true
+	}
+
+	return len(fields) > 1 && isSymbol(fields[0].Val) && strings.IndexByte("=;+-&|!", fields[1].Val[0]) < 0
+}
+
+// joinFields joins the fields of code by a single space, or by a comma
+// when a comma separated them
+func joinFields(code string, fields []Token) string {
+	var buf strings.Builder
+
+	for i, t := range fields {
+		if i > 0 {
+			prev := fields[i-1]
+
+			if gap := code[prev.Col(0)-1+len(prev.Val) : t.Col(0)-1]; strings.Contains(gap, ",") {
+				buf.WriteByte(',')
+			} else {
+				buf.WriteByte(' ')
+			}
+		}
+
+		buf.WriteString(t.Val)
+	}
+
+	return buf.String()
+}
+
+// formatTokens writes a parsed label or instruction in canonical form
+func formatTokens(tokens []Token) string {
+	switch tokens[0].Type {
+	case T_AINST:
+		return A + tokens[0].Val
+	case T_LABEL:
+		return string(LEFT_PAR) + tokens[0].Val + string(RIGHT_PAR)
+	}
+
+	dest, comp, jmp := cInstruction(tokens)
+	code := canonicalComp(comp)
+
+	if dest != "" {
+		code = canonicalDest(dest) + "=" + code
+	}
+
+	if jmp != "" {
+		code += ";" + jmp
+	}
+
+	return code
+}
+
+// canonicalDest orders the registers of dest as AMD, invalid dests are
+// kept as written
+func canonicalDest(dest string) string {
+	var result string
+
+	for _, reg := range []byte{A_REG, M_REG, D_REG} {
+		if n := strings.Count(dest, string(reg)); n > 1 {
+			return dest
+		} else if n == 1 {
+			result += string(reg)
+		}
+	}
+
+	if len(result) != len(dest) {
+		return dest
+	}
+
+	return result
+}
+
+// canonicalComp swaps the operands of comp aliases
+func canonicalComp(comp string) string {
+	for _, alias := range compAliases {
+		if comp == alias {
+			return comp[2:] + comp[1:2] + comp[:1]
+		}
+	}
+
+	return comp
+}
+
+// indentComments indents comments on their own line as the next line of
+// code in the same paragraph, or the previous one when there's none
+func indentComments(lines []fmtLine) {
+	for i := range lines {
+		if !lines[i].commentOnly() {
+			continue
+		}
+
+		found := false
+
+		for k := i + 1; k < len(lines) && (lines[k].code != "" || lines[k].comment != ""); k++ {
+			if lines[k].code != "" {
+				lines[i].indent, found = lines[k].indent, true
+				break
+			}
+		}
+
+		for k := i - 1; !found && k >= 0 && (lines[k].code != "" || lines[k].comment != ""); k-- {
+			if lines[k].code != "" {
+				lines[i].indent, found = lines[k].indent, true
+			}
+		}
+	}
+}
+
+// writeFormatted writes lines aligning the trailing comments of every run
+// of consecutive code lines, blank lines are written once and only
+// between other lines
+func writeFormatted(lines []fmtLine) []byte {
+	var buf bytes.Buffer
+	blank := false
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		if line.code == "" && line.comment == "" {
+			blank = buf.Len() > 0
+			i++
+			continue
+		}
+
+		if blank {
+			buf.WriteByte('\n')
+			blank = false
+		}
+
+		if line.commentOnly() {
+			fmt.Fprintf(&buf, "%s%s\n", strings.Repeat(INDENT, line.indent), line.comment)
+			i++
+			continue
+		}
+
+		end, col := i, 0
+
+		for ; end < len(lines) && lines[end].code != ""; end++ {
+			if lines[end].comment != "" && lines[end].width() > col {
+				col = lines[end].width()
+			}
+		}
+
+		for _, line := range lines[i:end] {
+			text := strings.Repeat(INDENT, line.indent) + line.code
+
+			if line.comment != "" {
+				text += strings.Repeat(" ", col-len(text)+1) + line.comment
+			}
+
+			fmt.Fprintln(&buf, text)
+		}
+
+		i = end
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,63 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := map[string]string{
+		"@ i\nM = 1\n":                                "    @i\n    M=1\n",
+		"  (LOOP)\n0 ; JMP\n":                         "(LOOP)\n    0;JMP\n",
+		"DA=A+D\nMD=M|D;JNE\n":                        "    AD=D+A\n    MD=D|M;JNE\n",
+		"@x // x\nD=M   // load x\n":                  "    @x  // x\n    D=M // load x\n",
+		"\n\n@1\n\n\n\n@2\n\n":                        "    @1\n\n    @2\n",
+		"// top\n  // before\n@1\n":                   "    // top\n    // before\n    @1\n",
+		"// top\n\n(END)\n// end\n":                   "// top\n\n(END)\n// end\n",
+		".equ  N ,10\n.var  a   b\n":                  ".equ N,10\n.var a b\n",
+		".macro  INC x\n(SKIP)\n@x\n.endm\nINC   i\n": ".macro INC x\n    (SKIP)\n        @x\n.endm\n    INC i\n",
+		"MOV D , A\nIFZ  D  END\nHALT\n":              "    MOV D,A\n    IFZ D END\n    HALT\n",
+		"PUT  x,1\n":                                  "    PUT x,1\n",
+		"D=M//no space\n":                             "    D=M //no space\n",
+	}
+
+	for src, expected := range tests {
+		out, err := Format("test.asm", strings.NewReader(src))
+
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", src, err)
+			continue
+		}
+
+		if string(out) != expected {
+			t.Errorf("Expected %q for %q, have %q", expected, src, out)
+		}
+
+		again, err := Format("test.asm", strings.NewReader(expected))
+
+		if err != nil || string(again) != expected {
+			t.Errorf("Expected %q to stay formatted, have %q (%v)", expected, again, err)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	_, err := Format("test.asm", strings.NewReader("@1\n(LOOP\n.foo\n"))
+
+	list, ok := err.(ErrorList)
+
+	if !ok || len(list) != 2 {
+		t.Fatalf("Expected 2 errors, have %v", err)
+	}
+
+	expected := []string{
+		`test.asm:2:6: missing ")"`,
+		`test.asm:3:1: unknown directive ".foo"`,
+	}
+
+	for i, e := range list {
+		if e.Error() != expected[i] {
+			t.Errorf("Expected %s, have %s", expected[i], e.Error())
+		}
+	}
+}
@@ -0,0 +1,928 @@
+// Package hack assembles Hack assembly of nand2tetris into machine code
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	COMMENT   = "//"
+	VAR       = "_var"
+	A         = "@"
+	LEFT_PAR  = '('
+	RIGHT_PAR = ')'
+	A_REG     = 'A'
+	D_REG     = 'D'
+	M_REG     = 'M'
+	PLUS      = '+'
+	MINUS     = '-'
+	AND       = '&'
+	OR        = '|'
+	ONE       = '1'
+	ZERO      = '0'
+	NEG       = '!'
+	JGT       = "JGT"
+	JEQ       = "JEQ"
+	JGE       = "JGE"
+	JLT       = "JLT"
+	JNE       = "JNE"
+	JLE       = "JLE"
+	JMP       = "JMP"
+
+	JGT_MASK = 1
+	JEQ_MASK = 2
+	JGE_MASK = 3
+	JLT_MASK = 4
+	JNE_MASK = 5
+	JLE_MASK = 6
+	JMP_MASK = 7
+
+	A_INST_MASK = (1 << 15)
+	C_INST_MASK = (7 << 13)
+
+	ZX     = (1 << 11)
+	NX     = (1 << 10)
+	ZY     = (1 << 9)
+	NY     = (1 << 8)
+	F      = (1 << 7)
+	NO     = (1 << 6)
+	A_COMP = (1 << 12)
+
+	A_DEST = (1 << 5)
+	D_DEST = (1 << 4)
+	M_DEST = (1 << 3)
+
+	DEST_MASK = A_DEST | D_DEST | M_DEST
+
+	T_AINST = iota
+	T_DEST
+	T_COMP
+	T_JMP
+	T_LABEL
+	T_DIRECTIVE
+	T_ARG
+)
+
+const (
+	S_PREDEFINED = iota
+	S_LABEL
+	S_VARIABLE
+	S_CONSTANT
+	S_IMPORT
+)
+
+// Symbol is an address together with the kind of symbol naming it
+type Symbol struct {
+	Addr uint16
+	Kind int
+}
+
+// SymbolTable maps symbol names to their addresses. The VAR entry holds
+// the address of the next variable.
+type SymbolTable map[string]Symbol
+
+// Copy returns a new table with the same symbols
+func (st SymbolTable) Copy() SymbolTable {
+	symbols := make(SymbolTable, len(st))
+
+	for k, v := range st {
+		symbols[k] = v
+	}
+
+	return symbols
+}
+
+// defaultSymbolTable is never modified, assembling works on copies
+var defaultSymbolTable = SymbolTable{
+	"R0":   {0, S_PREDEFINED},
+	"R1":   {1, S_PREDEFINED},
+	"R2":   {2, S_PREDEFINED},
+	"R3":   {3, S_PREDEFINED},
+	"R4":   {4, S_PREDEFINED},
+	"R5":   {5, S_PREDEFINED},
+	"R6":   {6, S_PREDEFINED},
+	"R7":   {7, S_PREDEFINED},
+	"R8":   {8, S_PREDEFINED},
+	"R9":   {9, S_PREDEFINED},
+	"R10":  {10, S_PREDEFINED},
+	"R11":  {11, S_PREDEFINED},
+	"R12":  {12, S_PREDEFINED},
+	"R13":  {13, S_PREDEFINED},
+	"R14":  {14, S_PREDEFINED},
+	"R15":  {15, S_PREDEFINED},
+	"SP":   {0, S_PREDEFINED},
+	"LCL":  {1, S_PREDEFINED},
+	"ARG":  {2, S_PREDEFINED},
+	"THIS": {3, S_PREDEFINED},
+	"THAT": {4, S_PREDEFINED},
+
+	"SCREEN": {0x4000, S_PREDEFINED},
+	"KBD":    {0x6000, S_PREDEFINED},
+
+	"_var": {16, S_PREDEFINED},
+}
+
+// DefaultSymbols returns a copy of the symbols predefined by the Hack
+// platform
+func DefaultSymbols() SymbolTable {
+	return defaultSymbolTable.Copy()
+}
+
+// Token is a part of an instruction: the value of an A-instruction,
+// a label or the dest, comp or jump of a C-instruction. Type is one of
+// the T_ constants and Cols holds the source column of every byte of Val.
+type Token struct {
+	Type uint16
+	Val  string
+	Cols []int
+}
+
+// Col returns the source column of the i-th byte of the token value,
+// or 0 when the token doesn't come from source
+func (t Token) Col(i int) int {
+	if len(t.Cols) == 0 {
+		return 0
+	}
+	return colAt(t.Cols, i)
+}
+
+// Line is a parsed source line together with its position and
+// the ROM address of its instruction. Lines expanded from a macro are
+// positioned in the macro body and refer to the macro call.
+type Line struct {
+	Tokens    []Token
+	File      string
+	Num       int
+	Src       string
+	Addr      uint16
+	Expansion *Expansion
+}
+
+// Origin returns the position of the line in the source, for lines
+// expanded from macros it's the outermost macro call
+func (l Line) Origin() (file string, num int) {
+	file, num = l.File, l.Num
+
+	for e := l.Expansion; e != nil; e = e.Parent {
+		file, num = e.File, e.Line
+	}
+
+	return
+}
+
+func stripComment(line string) string {
+	return strings.Split(line, COMMENT)[0]
+}
+
+func stripWhitespace(line string) (result string) {
+	result, _ = stripWhitespaceCols(line)
+	return
+}
+
+// stripWhitespaceCols works as stripWhitespace, but also returns
+// the source column (starting from 1) of every byte in the result
+func stripWhitespaceCols(line string) (result string, cols []int) {
+	buf := make([]byte, 0, len(line))
+	quoted := false
+
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ', '\t', '\r':
+			if quoted {
+				buf = append(buf, line[i])
+				cols = append(cols, i+1)
+			}
+		case '\'':
+			quoted = !quoted
+			fallthrough
+		default:
+			buf = append(buf, line[i])
+			cols = append(cols, i+1)
+		}
+	}
+
+	return string(buf), cols
+}
+
+func isAinstruction(line string) bool {
+	return strings.HasPrefix(line, A)
+}
+
+func isLabel(line string) bool {
+	return line[0] == LEFT_PAR && line[len(line)-1] == RIGHT_PAR
+}
+
+func isAddr(str string) bool {
+	for _, ch := range str {
+		if ch > '9' || ch < '0' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isSymbol checks that str is a valid symbol: a sequence of letters,
+// digits, "_", ".", "$" and ":" not starting with a digit
+func isSymbol(str string) bool {
+	if len(str) == 0 || (str[0] >= '0' && str[0] <= '9') {
+		return false
+	}
+
+	for _, ch := range str {
+		if !isSymbolChar(ch) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isSymbolChar(ch rune) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		strings.ContainsRune("_.$:", ch)
+}
+
+func isInList(n byte, list ...byte) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// colAt returns the source column of the i-th stripped byte, or the column
+// right after the line end
+func colAt(cols []int, i int) int {
+	switch {
+	case i < len(cols):
+		return cols[i]
+	case len(cols) > 0:
+		return cols[len(cols)-1] + 1
+	default:
+		return 1
+	}
+}
+
+func parseCInstruction(line string, cols []int) ([]Token, error) {
+	tokens := []Token{}
+	for _, str := range []string{"=", ";"} {
+		if strings.Count(line, str) > 1 {
+			i := strings.Index(line, str)
+			i += 1 + strings.Index(line[i+1:], str)
+			return nil, errorf(colAt(cols, i), "only one \"%s\" allowed", str)
+		}
+	}
+
+	var (
+		dest, comp, jmp string
+		compEnd         = len(line)
+	)
+
+	compStart := strings.Index(line, "=") + 1
+
+	if compStart > 0 {
+		dest = line[:compStart-1]
+	}
+	comp = line[compStart:]
+
+	if i := strings.Index(comp, ";"); i >= 0 {
+		compEnd = compStart + i
+		jmp = comp[i+1:]
+		comp = comp[:i]
+
+		if jmp == "" {
+			return nil, errorf(colAt(cols, compEnd+1), "missing jump after \";\"")
+		}
+	}
+
+	if compStart > 0 && dest == "" {
+		return nil, errorf(colAt(cols, 0), "missing dest before \"=\"")
+	}
+
+	if dest != "" {
+		tokens = append(tokens, Token{T_DEST, dest, cols[:compStart-1]})
+	}
+
+	if comp == "" {
+		return nil, errorf(colAt(cols, compStart), "missing comp")
+	} else {
+		tokens = append(tokens, Token{T_COMP, comp, cols[compStart:compEnd]})
+	}
+
+	if jmp != "" {
+		tokens = append(tokens, Token{T_JMP, jmp, cols[compEnd+1:]})
+	}
+
+	return tokens, nil
+}
+
+// ParseLine splits a source line into tokens, blank lines and comments
+// give no tokens
+func ParseLine(line string) ([]Token, error) {
+	if isDirective(line) {
+		return parseDirective(stripComment(line))
+	}
+
+	line, cols := stripWhitespaceCols(stripComment(line))
+
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	switch {
+	case isAinstruction(line):
+		if len(line) == 1 {
+			return nil, errorf(cols[0], "missing A-instruction value")
+		}
+		return []Token{Token{T_AINST, line[1:], cols[1:]}}, nil
+	case isLabel(line):
+		if name := line[1 : len(line)-1]; !isSymbol(name) && (name == "" || !isAddr(name)) {
+			return nil, errorf(cols[0], "invalid label name \"%s\"", line[1:len(line)-1])
+		}
+		return []Token{Token{T_LABEL, line[1 : len(line)-1], cols[1 : len(line)-1]}}, nil
+	case line[0] == LEFT_PAR:
+		return nil, errorf(colAt(cols, len(line)), "missing \"%c\"", RIGHT_PAR)
+	default:
+		return parseCInstruction(line, cols)
+	}
+}
+
+// parser collects the instructions, labels and directives of one or
+// more sources sharing a symbol table
+type parser struct {
+	symbols    SymbolTable
+	lines      []Line
+	labels     []Token
+	directives []Line
+	errs       ErrorList
+	lineIndex  uint16
+
+	// defined maps labels to the lines defining them
+	defined map[string]Line
+	global  string
+	anons   map[string]int
+	forward []anonRef
+
+	macros     map[string]*macro
+	macro      *macro
+	expansions int
+
+	includePath []string
+	open        func(path string) (io.ReadCloser, error)
+	files       []string
+
+	// object allows .import, imported symbols are resolved by Link
+	object bool
+
+	optimize      bool
+	optimizations []Optimization
+}
+
+func newParser(predefined SymbolTable) *parser {
+	return &parser{
+		symbols: predefined.Copy(),
+		defined: map[string]Line{},
+		anons:   map[string]int{},
+		macros:  map[string]*macro{},
+		open:    openFile,
+	}
+}
+
+func openFile(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// parseLines parses the source read from r, the returned symbol table
+// contains predefined symbols, all labels and symbols defined by
+// directives
+func parseLines(name string, r io.Reader, predefined SymbolTable) (lines []Line, symbols SymbolTable, data []Data, err error) {
+	p := newParser(predefined)
+
+	if err = p.parse(name, r, nil); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return p.finish()
+}
+
+// parse parses the source read from r, included is the .include line
+// of included sources
+func (p *parser) parse(name string, r io.Reader, included *Expansion) error {
+	scanner := bufio.NewScanner(r)
+
+	p.files = append(p.files, name)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+
+	for num := 1; scanner.Scan(); num++ {
+		p.line(Line{File: name, Num: num, Src: scanner.Text(), Expansion: included})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: can't read source: %v", name, err)
+	}
+
+	if p.macro != nil {
+		def := splitFields(stripComment(p.macro.def.Src))
+		p.errs.add(p.macro.def, errorf(def[0].Col(0), "missing .endm"))
+		p.macro = nil
+	}
+
+	return nil
+}
+
+// finish defines the labels at the end of the sources, runs the optimizer
+// when enabled and executes directives
+func (p *parser) finish() (lines []Line, symbols SymbolTable, data []Data, err error) {
+	for _, label := range p.labels {
+		p.symbols[label.Val] = Symbol{p.lineIndex, S_LABEL}
+	}
+
+	p.checkForward()
+
+	if p.optimize && len(p.errs) == 0 {
+		p.optimizeLines()
+	}
+
+	data, errs := defineDirectives(p.directives, p.symbols)
+
+	return p.lines, p.symbols, data, append(p.errs, errs...).Err()
+}
+
+// line parses a line of the source or of a macro expansion
+func (p *parser) line(line Line) {
+	if p.record(line) || p.expand(line) {
+		return
+	}
+
+	var err error
+
+	line.Addr = p.lineIndex
+	line.Tokens, err = ParseLine(line.Src)
+
+	if err != nil {
+		p.errs.add(line, err)
+
+		if fields := splitFields(stripComment(line.Src)); fields[0].Val == ".macro" {
+			// skip the body of an invalid definition
+			p.macro = &macro{labels: map[string]bool{}, def: line}
+		}
+		return
+	}
+
+	if line.Tokens == nil {
+		return
+	}
+
+	if err = p.scope(&line); err != nil {
+		p.errs.add(line, err)
+		return
+	}
+
+	switch line.Tokens[0].Type {
+	case T_LABEL:
+		label := line.Tokens[0]
+
+		if prev, ok := p.defined[label.Val]; ok {
+			p.errs.add(line, errorf(label.Col(0), "label \"%s\" already defined at %s:%d", label.Val, prev.File, prev.Num))
+			return
+		}
+
+		p.defined[label.Val] = line
+		p.labels = append(p.labels, label)
+	case T_DIRECTIVE:
+		switch line.Tokens[0].Val {
+		case ".macro":
+			p.startMacro(line)
+		case ".endm":
+			p.errs.add(line, errorf(line.Tokens[0].Col(0), ".endm without .macro"))
+		case ".include":
+			p.include(line)
+		case ".import":
+			if !p.object {
+				p.errs.add(line, errorf(line.Tokens[0].Col(0), ".import is only allowed in object files"))
+				return
+			}
+			p.directives = append(p.directives, line)
+		default:
+			p.directives = append(p.directives, line)
+		}
+	default:
+		for _, label := range p.labels {
+			p.symbols[label.Val] = Symbol{p.lineIndex, S_LABEL}
+		}
+		p.labels = nil
+		p.lines = append(p.lines, line)
+		p.lineIndex++
+	}
+}
+
+func symbolToAddr(symbol string, symbols SymbolTable) uint16 {
+	_, ok := symbols[symbol]
+
+	if !ok {
+		next := symbols[VAR]
+		symbols[symbol] = Symbol{next.Addr, S_VARIABLE}
+		next.Addr++
+		symbols[VAR] = next
+	}
+
+	return symbols[symbol].Addr
+}
+
+// compileAinstruction evaluates the value of an A-instruction after
+// labels are resolved, see evalExpr
+func compileAinstruction(line []Token, symbols SymbolTable) (i uint16, err error) {
+	t := line[0]
+	value, err := evalExpr(t, symbols)
+
+	switch {
+	case err != nil:
+		return 0, err
+	case value >= 0 && value <= MAX_VALUE:
+		return uint16(value), nil
+	case isAddr(t.Val):
+		return 0, errorf(t.Col(0), "address %s out of range 0..%d", t.Val, MAX_VALUE)
+	default:
+		return 0, errorf(t.Col(0), "value %d of \"%s\" out of range 0..%d", value, t.Val, MAX_VALUE)
+	}
+}
+
+func compileDest(t Token) (mask uint16, err error) {
+	for i, ch := range t.Val {
+		if isInList(byte(ch), A_REG, D_REG, M_REG) &&
+			strings.ContainsRune(t.Val[i+1:], ch) {
+			return 0, didYouMean(errorf(t.Col(i), "duplicated dest register '%c'", ch),
+				suggest(t.Val, legalDests))
+		}
+
+		switch ch {
+		case A_REG:
+			mask |= A_DEST
+		case M_REG:
+			mask |= M_DEST
+		case D_REG:
+			mask |= D_DEST
+		default:
+			return 0, didYouMean(errorf(t.Col(i), "unknown register '%c'", ch),
+				suggest(t.Val, legalDests))
+		}
+	}
+
+	return
+}
+
+// compileComp1, compileComp2 and compileComp3 report error columns
+// as offsets into comp, compileComp maps them to source columns
+
+func compileComp1(ch byte) (mask uint16, err error) {
+	switch ch {
+	case ZERO:
+		return ZX | ZY | F, nil
+	case ONE:
+		return ZX | NX | ZY | NY | F | NO, nil
+	case D_REG:
+		return ZY | NY, nil
+	case A_REG:
+		return ZX | NX, nil
+	case M_REG:
+		return ZX | NX | A_COMP, nil
+	default:
+		return 0, errorf(0, "unexpected comp '%c'", ch)
+	}
+}
+
+func compileComp2(operator byte, operand byte) (mask uint16, err error) {
+	switch operand {
+	case A_REG:
+		mask |= ZX | NX
+	case M_REG:
+		mask |= ZX | NX | A_COMP
+	case D_REG:
+		mask |= ZY | NY
+	case ONE:
+		mask |= ZX | NX | ZY | F
+	default:
+		return 0, errorf(1, "unknown operand '%c'", operand)
+	}
+
+	switch operator {
+	case MINUS:
+		if operand != ONE {
+			mask |= F | NO
+		}
+	case NEG:
+		mask |= NO
+	default:
+		return 0, errorf(0, "unexpected operator '%c'", operator)
+	}
+
+	return
+}
+
+func compileComp3(operand1 byte, operator byte, operand2 byte) (mask uint16, err error) {
+	if !isInList(operand1, A_REG, M_REG, D_REG, ONE) {
+		return 0, errorf(0, "unknown register '%c'", operand1)
+	}
+
+	if !isInList(operand2, A_REG, M_REG, D_REG, ONE) {
+		return 0, errorf(2, "unknown register '%c'", operand2)
+	}
+
+	if !isInList(operator, PLUS, MINUS, AND, OR) {
+		return 0, errorf(1, "unexpected operator '%c'", operator)
+	}
+
+	if operand1 == operand2 {
+		return 0, errorf(0, "equal operands not allowed")
+	}
+
+	if (operand1 == A_REG || operand1 == M_REG) &&
+		(operand2 == A_REG || operand2 == M_REG) {
+		return 0, errorf(0, "can't operate on A and M simultaneously")
+	}
+
+	if isInList(M_REG, operand1, operand2) {
+		mask |= A_COMP
+	}
+
+	if !isInList(A_REG, operand1, operand2) && !isInList(M_REG, operand1, operand2) {
+		mask |= ZY | NY
+	}
+
+	if !isInList(D_REG, operand1, operand2) {
+		mask |= ZX | NX
+	}
+
+	if isInList(operator, MINUS, PLUS) {
+		mask |= F
+	}
+
+	switch operator {
+	case OR:
+		mask |= NX | NY | NO
+
+	case AND:
+
+	case PLUS:
+		if operand2 == ONE {
+			mask |= NO
+
+			switch operand1 {
+			case A_REG, M_REG:
+				mask |= NY
+			case D_REG:
+				mask |= NX
+			}
+		}
+
+	case MINUS:
+		if operand2 != ONE {
+			mask |= NO
+
+			switch operand1 {
+			case A_REG, M_REG:
+				mask |= NY
+			case D_REG:
+				mask |= NX
+			}
+		}
+	}
+
+	return
+}
+
+func compileComp(t Token) (mask uint16, err error) {
+	switch len(t.Val) {
+	case 1:
+		mask, err = compileComp1(t.Val[0])
+	case 2:
+		mask, err = compileComp2(t.Val[0], t.Val[1])
+	case 3:
+		mask, err = compileComp3(t.Val[0], t.Val[1], t.Val[2])
+	default:
+		err = errorf(0, "don't know how to handle comp \"%s\"", t.Val)
+	}
+
+	if err == nil && !isLegalComp(t.Val) {
+		err = errorf(0, "invalid comp \"%s\"", t.Val)
+	}
+
+	if err != nil {
+		e := err.(*Error)
+		e.Column = t.Col(e.Column)
+		return 0, didYouMean(e, suggestComp(t.Val))
+	}
+
+	return
+}
+
+func compileJmp(t Token) (mask uint16, err error) {
+	switch t.Val {
+	case JGT:
+		mask |= JGT_MASK
+	case JEQ:
+		mask |= JEQ_MASK
+	case JGE:
+		mask |= JGE_MASK
+	case JLT:
+		mask |= JLT_MASK
+	case JNE:
+		mask |= JNE_MASK
+	case JLE:
+		mask |= JLE_MASK
+	case JMP:
+		mask |= JMP_MASK
+	default:
+		return 0, didYouMean(errorf(t.Col(0), "unknown jump \"%s\"", t.Val),
+			suggest(t.Val, jmpNames[1:]))
+	}
+	return
+}
+
+func compileCinstruction(line []Token) (i uint16, err error) {
+	i |= C_INST_MASK
+
+	for _, t := range line {
+		var mask uint16
+
+		switch t.Type {
+		case T_DEST:
+			mask, err = compileDest(t)
+		case T_COMP:
+			mask, err = compileComp(t)
+		case T_JMP:
+			mask, err = compileJmp(t)
+		default:
+			err = errorf(t.Col(0), "unknown token type %d", t.Type)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		i |= mask
+	}
+
+	return i, nil
+}
+
+func compileLine(line []Token, symbols SymbolTable) (uint16, error) {
+	if line[0].Type == T_AINST {
+		return compileAinstruction(line, symbols)
+	} else {
+		return compileCinstruction(line)
+	}
+}
+
+// Assembler translates Hack assembly into machine code. It keeps no
+// state between calls, so one Assembler may be used concurrently.
+type Assembler struct {
+	// File names the source in errors, included files are searched
+	// next to it
+	File string
+	// Symbols are defined before assembling, DefaultSymbols() when nil.
+	// They are copied and never modified.
+	Symbols SymbolTable
+	// IncludePath lists directories searched for included files which
+	// are not found next to the including file
+	IncludePath []string
+	// Open opens included files and the files of AssembleFiles,
+	// os.Open when nil
+	Open func(path string) (io.ReadCloser, error)
+	// Optimize removes instructions without effect before labels are
+	// used, see Program.Optimizations
+	Optimize bool
+}
+
+// Program is the result of assembling a source
+type Program struct {
+	Code []uint16
+	// Lines are the parsed instructions, Lines[i] is encoded in Code[i]
+	Lines []Line
+	// Symbols holds the predefined symbols, labels, constants and
+	// variables
+	Symbols SymbolTable
+	// Data is the initial RAM contents defined with .data
+	Data []Data
+	// Optimizations lists the instructions removed by the optimizer,
+	// one for every word saved
+	Optimizations []Optimization
+	// Labels maps labels to the lines defining them
+	Labels map[string]Line
+	// Directives are the lines of directives executed after parsing
+	Directives []Line
+}
+
+// New returns an Assembler for the named source with the default symbols
+func New(file string) *Assembler {
+	return &Assembler{File: file}
+}
+
+// Assemble assembles the source read from r. All errors found are
+// returned as an ErrorList.
+func (a *Assembler) Assemble(r io.Reader) ([]uint16, error) {
+	prog, err := a.AssembleProgram(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return prog.Code, nil
+}
+
+// AssembleProgram works as Assemble, but also returns the parsed lines
+// and the symbol table with all variables allocated
+func (a *Assembler) AssembleProgram(r io.Reader) (*Program, error) {
+	p := a.newParser()
+
+	if err := p.parse(a.File, r, nil); err != nil {
+		return nil, err
+	}
+
+	return p.assemble()
+}
+
+// AssembleFiles assembles the concatenation of files into a single
+// program, they share one symbol table
+func (a *Assembler) AssembleFiles(paths ...string) (*Program, error) {
+	p := a.newParser()
+
+	for _, path := range paths {
+		r, err := p.open(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.parse(path, r, nil)
+		r.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p.assemble()
+}
+
+func (a *Assembler) newParser() *parser {
+	predefined := a.Symbols
+
+	if predefined == nil {
+		predefined = defaultSymbolTable
+	}
+
+	p := newParser(predefined)
+	p.includePath = a.IncludePath
+	p.optimize = a.Optimize
+
+	if a.Open != nil {
+		p.open = a.Open
+	}
+
+	return p
+}
+
+// assemble encodes the parsed instructions
+func (p *parser) assemble() (*Program, error) {
+	lines, symbols, data, err := p.finish()
+
+	errs, ok := err.(ErrorList)
+
+	if err != nil && !ok {
+		return nil, err
+	}
+
+	var code []uint16
+
+	for _, l := range lines {
+		i, err := compileLine(l.Tokens, symbols)
+
+		if err != nil {
+			errs.add(l, err)
+		}
+
+		code = append(code, i)
+	}
+
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, errs
+	}
+
+	return &Program{
+		Code:          code,
+		Lines:         lines,
+		Symbols:       symbols,
+		Data:          data,
+		Optimizations: p.optimizations,
+		Labels:        p.defined,
+		Directives:    p.directives,
+	}, nil
+}
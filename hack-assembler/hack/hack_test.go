@@ -1,4 +1,4 @@
-package main
+package hack
 
 import (
 	"fmt"
@@ -6,6 +6,16 @@ import (
 	"testing"
 )
 
+func mustCompile(t *testing.T, src string) []uint16 {
+	code, err := New("test.asm").Assemble(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
 func TestStripComment(t *testing.T) {
 	examples := map[string]string{
 		"abcd//abcd":  "abcd",
@@ -60,166 +70,168 @@ func TestIsLabel(t *testing.T) {
 }
 
 func TestParseAinstruction(t *testing.T) {
-	tokens := parseLine("@a")
+	tokens, _ := ParseLine("@a")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatalf("Wrong tokens size: %d", len(tokens))
 
-	case tokens[0].t != T_AINST:
-		t.Fatalf("Expected to have AInstruction, but have %d", tokens[0].t)
+	case tokens[0].Type != T_AINST:
+		t.Fatalf("Expected to have AInstruction, but have %d", tokens[0].Type)
 
-	case tokens[0].val != "a":
+	case tokens[0].Val != "a":
 		t.Fatal("Expected first token value to be \"a\"")
 	}
 
 }
 
 func TestParseLabel(t *testing.T) {
-	tokens := parseLine("(ABC)")
+	tokens, _ := ParseLine("(ABC)")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatalf("Wrong tokens size: %d", len(tokens))
 
-	case tokens[0].val != "ABC":
-		t.Fatalf("Token should eq ABC, but have: %s", tokens[0].val)
+	case tokens[0].Val != "ABC":
+		t.Fatalf("Token should eq ABC, but have: %s", tokens[0].Val)
 	}
 
 }
 
 func TestParseDestComp(t *testing.T) {
-	tokens := parseLine("A=D")
+	tokens, _ := ParseLine("A=D")
 
 	switch {
 
 	case len(tokens) != 2:
 		t.Fatalf("Expected 3 tokens, but have: %d", len(tokens))
 
-	case tokens[0].t != T_DEST:
+	case tokens[0].Type != T_DEST:
 		t.Fatal("First token type should be a T_DEST")
 
-	case tokens[0].val != "A":
+	case tokens[0].Val != "A":
 		t.Fatal("First token value should be \"A\"")
 
-	case tokens[1].t != T_COMP:
+	case tokens[1].Type != T_COMP:
 		t.Fatal("Third token type should be a T_COMP")
 
-	case tokens[1].val != "D":
+	case tokens[1].Val != "D":
 		t.Fatal("Third token value should be \"A\"")
 	}
 }
 
 func TestParseComp(t *testing.T) {
-	tokens := parseLine("M")
+	tokens, _ := ParseLine("M")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatalf("Expected 1 token, but have: %d", len(tokens))
 
-	case tokens[0].t != T_COMP:
+	case tokens[0].Type != T_COMP:
 		t.Fatal("Expected first token to be T_COMP")
 
-	case tokens[0].val != "M":
+	case tokens[0].Val != "M":
 		t.Fatal("Expected first token val to be \"M\"")
 	}
 }
 
 func TestParseMinusComp(t *testing.T) {
-	tokens := parseLine("-M")
+	tokens, _ := ParseLine("-M")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatalf("Expected 2 tokens, but have: %d", len(tokens))
 
-	case tokens[0].t != T_COMP:
+	case tokens[0].Type != T_COMP:
 		t.Fatal("Expected first token to be T_COMP")
 
-	case tokens[0].val != "-M":
+	case tokens[0].Val != "-M":
 		t.Fatal("Expected first token value to be \"-M\"")
 	}
 }
 
 func TestParseCompPlusComp(t *testing.T) {
-	tokens := parseLine("M+A")
+	tokens, _ := ParseLine("M+A")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatalf("Expected 3 tokens, but have: %d", len(tokens))
 
-	case tokens[0].t != T_COMP:
+	case tokens[0].Type != T_COMP:
 		t.Fatal("Expected first token to be T_COMP")
 
-	case tokens[0].val != "M+A":
+	case tokens[0].Val != "M+A":
 		t.Fatal("Expected first token val to eq \"M+A\"")
 	}
 }
 
 func TestParseOneComp(t *testing.T) {
-	tokens := parseLine("1")
+	tokens, _ := ParseLine("1")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatal("Expected to have 1 token")
 
-	case tokens[0].t != T_COMP:
+	case tokens[0].Type != T_COMP:
 		t.Fatal("Expected first token to be T_COMP")
 
-	case tokens[0].val != "1":
+	case tokens[0].Val != "1":
 		t.Fatal("Expected first token value to eq \"1\"")
 	}
 }
 
 func TestParseZeroComp(t *testing.T) {
-	tokens := parseLine("0")
+	tokens, _ := ParseLine("0")
 
 	switch {
 	case len(tokens) != 1:
 		t.Fatal("Expected to have one token")
 
-	case tokens[0].t != T_COMP:
+	case tokens[0].Type != T_COMP:
 		t.Fatal("Expected first token to be T_COMP")
 
-	case tokens[0].val != "0":
+	case tokens[0].Val != "0":
 		t.Fatal("Expected first token value to be \"0\"")
 	}
 }
 
 func TestParseJMP(t *testing.T) {
-	tokens := parseLine("D=0;JMP")
+	tokens, _ := ParseLine("D=0;JMP")
 
 	switch {
 	case len(tokens) != 3:
 		t.Fatal("Expected to have 3 tokens")
-	case tokens[0].t != T_DEST:
+	case tokens[0].Type != T_DEST:
 		t.Fatal("Expected first token to be T_DEST")
-	case tokens[0].val != "D":
+	case tokens[0].Val != "D":
 		t.Fatal("Expected first token value to eq \"D\"")
-	case tokens[1].t != T_COMP:
+	case tokens[1].Type != T_COMP:
 		t.Fatal("Expected second token to be T_COMP")
-	case tokens[1].val != "0":
+	case tokens[1].Val != "0":
 		t.Fatal("Expected second token value to eq \"0\"")
-	case tokens[2].t != T_JMP:
+	case tokens[2].Type != T_JMP:
 		t.Fatal("Expected third token to be T_JMP")
-	case tokens[2].val != "JMP":
+	case tokens[2].Val != "JMP":
 		t.Fatal("Expected third token value to eq \"JMP\"")
 	}
 }
 
 func TestParseEmptyLine(t *testing.T) {
-	if parseLine("") != nil {
+	if tokens, err := ParseLine(""); tokens != nil || err != nil {
 		t.Fatalf("Empty line should be parsed as nil")
 	}
 }
 
 func TestParseLines(t *testing.T) {
-	lines, symbols := parseLines(strings.NewReader("(A)\n@A\nD;JMP\nAM=D+1;JLE"))
+	lines, symbols, _, err := parseLines("test.asm", strings.NewReader("(A)\n@A\nD;JMP\nAM=D+1;JLE"), defaultSymbolTable)
 
 	switch {
+	case err != nil:
+		t.Fatal(err)
 	case len(lines) != 3:
 		t.Fatalf("Size of lines should be 2, but have: %d", len(lines))
-	case symbols["A"] != 0:
-		t.Fatal("Symbol A should eq 0")
+	case symbols["A"] != Symbol{0, S_LABEL}:
+		t.Fatal("Symbol A should be a label at 0")
 	}
 
 	for k, v := range defaultSymbolTable {
@@ -230,28 +242,25 @@ func TestParseLines(t *testing.T) {
 }
 
 func TestSymbolToAddr(t *testing.T) {
-	table := make(SymbolTable)
-	for k, v := range defaultSymbolTable {
-		table[k] = v
-	}
+	table := DefaultSymbols()
 
 	res := symbolToAddr("i", table)
 
 	if res != 16 {
-		t.Fatal("\"i\" symbol should be %d, but have %d", 16, res)
+		t.Fatalf("\"i\" symbol should be %d, but have %d", 16, res)
 	}
 
-	if table["i"] != 16 {
+	if table["i"] != (Symbol{16, S_VARIABLE}) {
 		t.Fatal("table should contain i symbol")
 	}
 
 	res = symbolToAddr("j", table)
 
 	if res != 17 {
-		t.Fatal("\"j\" symbol should be %d, but have %d", 17, res)
+		t.Fatalf("\"j\" symbol should be %d, but have %d", 17, res)
 	}
 
-	if table["j"] != 17 {
+	if table["j"] != (Symbol{17, S_VARIABLE}) {
 		t.Fatal("table should contain i symbol")
 	}
 }
@@ -266,7 +275,11 @@ func TestIsAddr(t *testing.T) {
 }
 
 func TestCompileAInstruction(t *testing.T) {
-	res := compileLine([]Token{Token{T_AINST, fmt.Sprintf("%d", 0x7fff)}}, defaultSymbolTable)
+	res, err := compileLine([]Token{Token{Type: T_AINST, Val: fmt.Sprintf("%d", 0x7fff)}}, DefaultSymbols())
+
+	if err != nil {
+		t.Fatal(err)
+	}
 	switch {
 	case res != 0x7fff:
 		t.Errorf("@32767 should be 0111111111111111, but have: %b", res)
@@ -274,7 +287,11 @@ func TestCompileAInstruction(t *testing.T) {
 }
 
 func TestCompileSimpleD(t *testing.T) {
-	res := compileLine([]Token{Token{T_COMP, "D"}}, defaultSymbolTable)
+	res, err := compileLine([]Token{Token{Type: T_COMP, Val: "D"}}, DefaultSymbols())
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	var expected uint16 = C_INST_MASK | ZY | NY
 
@@ -284,7 +301,11 @@ func TestCompileSimpleD(t *testing.T) {
 }
 
 func TestCompileZeroJMP(t *testing.T) {
-	res := compileLine([]Token{Token{T_COMP, "0"}, Token{T_JMP, "JMP"}}, defaultSymbolTable)
+	res, err := compileLine([]Token{Token{Type: T_COMP, Val: "0"}, Token{Type: T_JMP, Val: "JMP"}}, DefaultSymbols())
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	var expected uint16 = C_INST_MASK | ZX | ZY | F | JMP_MASK
 
@@ -294,11 +315,15 @@ func TestCompileZeroJMP(t *testing.T) {
 }
 
 func TestCompileComplexCinstruction(t *testing.T) {
-	res := compileLine([]Token{
-		Token{T_DEST, "AD"},
-		Token{T_COMP, "M-D"},
-		Token{T_JMP, "JGE"},
-	}, defaultSymbolTable)
+	res, err := compileLine([]Token{
+		Token{Type: T_DEST, Val: "AD"},
+		Token{Type: T_COMP, Val: "M-D"},
+		Token{Type: T_JMP, Val: "JGE"},
+	}, DefaultSymbols())
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	var expected uint16 = C_INST_MASK | NY | F | NO | A_COMP | JGE_MASK | A_DEST | D_DEST
 
@@ -308,10 +333,14 @@ func TestCompileComplexCinstruction(t *testing.T) {
 }
 
 func TestCompileDeqA(t *testing.T) {
-	res := compileLine([]Token{
-		Token{T_DEST, "D"},
-		Token{T_COMP, "A"},
-	}, defaultSymbolTable)
+	res, err := compileLine([]Token{
+		Token{Type: T_DEST, Val: "D"},
+		Token{Type: T_COMP, Val: "A"},
+	}, DefaultSymbols())
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	var expected uint16 = C_INST_MASK | NX | ZX | D_DEST
 
@@ -321,10 +350,68 @@ func TestCompileDeqA(t *testing.T) {
 }
 
 func TestCompileMinusOne(t *testing.T) {
-	res := compileLine([]Token{
-		Token{T_DEST, "M"},
-		Token{T_COMP, "-1"},
-	}, defaultSymbolTable)
+	res, err := compileLine([]Token{
+		Token{Type: T_DEST, Val: "M"},
+		Token{Type: T_COMP, Val: "-1"},
+	}, DefaultSymbols())
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	var expected uint16 = C_INST_MASK | M_DEST | ZX | NX | ZY | F
+
+	if res != expected {
+		t.Errorf("%b should eq %b", res, expected)
+	}
+}
+
+func TestAssemblerKeepsSymbols(t *testing.T) {
+	predefined := DefaultSymbols()
+	a := &Assembler{File: "test.asm", Symbols: predefined}
+
+	for n := 0; n < 2; n++ {
+		prog, err := a.AssembleProgram(strings.NewReader("@i\n@j\n(END)\n@END\n0;JMP"))
+
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case prog.Code[0] != 16 || prog.Code[1] != 17:
+			t.Fatalf("Variables should be allocated from 16 on every run, have %v", prog.Code)
+		case prog.Symbols["END"] != (Symbol{2, S_LABEL}):
+			t.Fatalf("END should be a label at 2, have %v", prog.Symbols["END"])
+		}
+	}
+
+	if _, ok := predefined["i"]; ok || predefined[VAR].Addr != 16 {
+		t.Error("Assembling should not modify predefined symbols")
+	}
+
+	if _, ok := defaultSymbolTable["i"]; ok {
+		t.Error("Assembling should not modify default symbols")
+	}
+}
+
+func TestAssembleConcurrently(t *testing.T) {
+	a := New("test.asm")
+	errs := make(chan error)
+
+	for n := 0; n < 8; n++ {
+		go func(n int) {
+			src := fmt.Sprintf("@v%d\nM=0\n@w\nM=1", n)
+			code, err := a.Assemble(strings.NewReader(src))
+
+			if err == nil && (code[0] != 16 || code[2] != 17) {
+				err = fmt.Errorf("expected variables at 16 and 17, have %v", code)
+			}
+
+			errs <- err
+		}(n)
+	}
+
+	for n := 0; n < 8; n++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
 }
@@ -0,0 +1,51 @@
+package hack
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// include parses the file named by an .include line. Relative names
+// are searched next to the including file, then in the include path.
+func (p *parser) include(line Line) {
+	arg := line.Tokens[1]
+	name := arg.Val[1 : len(arg.Val)-1]
+
+	var candidates []string
+
+	if filepath.IsAbs(name) {
+		candidates = append(candidates, name)
+	} else {
+		candidates = append(candidates, filepath.Join(filepath.Dir(line.File), name))
+
+		for _, dir := range p.includePath {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+
+	for _, path := range candidates {
+		r, err := p.open(path)
+
+		if err != nil {
+			continue
+		}
+		defer r.Close()
+
+		for i, file := range p.files {
+			if filepath.Clean(file) == filepath.Clean(path) {
+				cycle := strings.Join(append(p.files[i:], path), " -> ")
+				p.errs.add(line, errorf(arg.Col(0), "include cycle %s", cycle))
+				return
+			}
+		}
+
+		included := &Expansion{File: line.File, Line: line.Num, Parent: line.Expansion}
+
+		if err = p.parse(path, r, included); err != nil {
+			p.errs.add(line, errorf(arg.Col(0), "%v", err))
+		}
+		return
+	}
+
+	p.errs.add(line, errorf(arg.Col(0), "can't find included file \"%s\"", name))
+}
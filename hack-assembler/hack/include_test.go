@@ -0,0 +1,117 @@
+package hack
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memFiles opens files from a map of paths to sources
+func memFiles(files map[string]string) func(string) (io.ReadCloser, error) {
+	return func(path string) (io.ReadCloser, error) {
+		src, ok := files[filepath.ToSlash(path)]
+
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		return ioutil.NopCloser(strings.NewReader(src)), nil
+	}
+}
+
+func TestInclude(t *testing.T) {
+	a := &Assembler{
+		IncludePath: []string{"std"},
+		Open: memFiles(map[string]string{
+			"src/main.asm":     ".include \"lib/math.asm\"\n@x\nD=M\n",
+			"src/lib/math.asm": "@1\n.include \"zero.asm\"\n",
+			"std/zero.asm":     "(ZERO)\n@x\nM=0\n",
+		}),
+	}
+
+	prog, err := a.AssembleFiles("src/main.asm")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := mustCompile(t, "@1\n@16\nM=0\n@16\nD=M"); !reflect.DeepEqual(prog.Code, expected) {
+		t.Errorf("Expected %v, have %v", expected, prog.Code)
+	}
+
+	if prog.Symbols["ZERO"] != (Symbol{1, S_LABEL}) {
+		t.Errorf("ZERO should be a label at 1, have %v", prog.Symbols["ZERO"])
+	}
+
+	line := prog.Lines[2]
+	file, num := line.Origin()
+
+	switch {
+	case filepath.ToSlash(line.File) != "std/zero.asm" || line.Num != 3:
+		t.Errorf("Expected line std/zero.asm:3, have %s:%d", line.File, line.Num)
+	case file != "src/main.asm" || num != 1:
+		t.Errorf("Expected origin src/main.asm:1, have %s:%d", file, num)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	a := &Assembler{
+		Open: memFiles(map[string]string{
+			"main.asm": ".include \"bad.asm\"\n.include \"a.asm\"\n.include \"none.asm\"\n.include none.asm",
+			"bad.asm":  "@1\nD=X\n",
+			"a.asm":    ".include \"b.asm\"",
+			"b.asm":    "\t.include \"a.asm\"",
+		}),
+	}
+
+	_, err := a.AssembleFiles("main.asm")
+	errs, ok := err.(ErrorList)
+
+	if !ok {
+		t.Fatalf("Expected ErrorList, have %v", err)
+	}
+
+	expected := []string{
+		"b.asm:1:11: include cycle a.asm -> b.asm -> a.asm (included from a.asm:1, included from main.asm:2)",
+		"bad.asm:2:3: unexpected comp 'X' (included from main.asm:1)",
+		"main.asm:3:10: can't find included file \"none.asm\"",
+		"main.asm:4:10: expected .include \"FILE\"",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, have %v", len(expected), errs)
+	}
+
+	for i := range expected {
+		if errs[i].Error() != expected[i] {
+			t.Errorf("Expected %q, have %q", expected[i], errs[i])
+		}
+	}
+}
+
+func TestAssembleFiles(t *testing.T) {
+	a := &Assembler{
+		Open: memFiles(map[string]string{
+			"a.asm": ".macro CLEAR x\n@x\nM=0\n.endm\nCLEAR i\n@END\n0;JMP\n",
+			"b.asm": "(END)\nCLEAR j\n@i\n",
+		}),
+	}
+
+	prog, err := a.AssembleFiles("a.asm", "b.asm")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []uint16{16, 0xEA88, 4, 0xEA87, 17, 0xEA88, 16}; !reflect.DeepEqual(prog.Code, expected) {
+		t.Errorf("Expected %v, have %v", expected, prog.Code)
+	}
+
+	if _, err = a.AssembleFiles("a.asm", "missing.asm"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, have %v", err)
+	}
+}
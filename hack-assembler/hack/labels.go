@@ -0,0 +1,150 @@
+package hack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// anonRef is a forward reference to an anonymous label, the label must
+// be defined at least n times
+type anonRef struct {
+	line  Line
+	col   int
+	label string
+	n     int
+}
+
+func isLocalLabel(name string) bool {
+	return strings.HasPrefix(name, string(DIRECTIVE))
+}
+
+// anonLabel returns the global name of the n-th definition of the
+// anonymous label
+func anonLabel(label string, n int) string {
+	return fmt.Sprintf("$%s.%d", label, n)
+}
+
+// anonReference splits a reference like 1f or 2b into its label and
+// direction
+func anonReference(name string) (label string, dir byte, ok bool) {
+	if len(name) < 2 || !isAddr(name[:len(name)-1]) {
+		return "", 0, false
+	}
+
+	switch dir = name[len(name)-1]; dir {
+	case 'f', 'b':
+		return name[:len(name)-1], dir, true
+	}
+
+	return "", 0, false
+}
+
+// scope gives local and anonymous labels of line global names. Local
+// labels start with "." and belong to the last global label defined
+// outside macros, anonymous labels are numbers referenced as Nf for the
+// next definition and Nb for the previous one.
+func (p *parser) scope(line *Line) error {
+	tokens := line.Tokens
+	var args []Token
+
+	switch t := &tokens[0]; t.Type {
+	case T_LABEL:
+		switch {
+		case isAddr(t.Val):
+			p.anons[t.Val]++
+			t.Val = anonLabel(t.Val, p.anons[t.Val])
+		case isLocalLabel(t.Val):
+			t.Val = p.global + t.Val
+		case line.Expansion == nil || line.Expansion.Macro == "":
+			p.global = t.Val
+		}
+		return nil
+
+	case T_AINST:
+		args = tokens[:1]
+
+	case T_DIRECTIVE:
+		switch t.Val {
+		case ".equ", ".var":
+			args = tokens[2:]
+		case ".data":
+			args = tokens[1:]
+		}
+	}
+
+	for i := range args {
+		t, err := p.renameLabels(*line, args[i])
+
+		if err != nil {
+			return err
+		}
+
+		args[i] = t
+	}
+
+	return nil
+}
+
+// renameLabels replaces references to local and anonymous labels in the
+// expression t by their global names
+func (p *parser) renameLabels(line Line, t Token) (Token, error) {
+	renamed := Token{Type: t.Type}
+
+	for i := 0; i < len(t.Val); {
+		start := i
+
+		switch {
+		case t.Val[i] == '\'':
+			i += 3
+		case !isSymbolChar(rune(t.Val[i])):
+			i++
+		default:
+			for i < len(t.Val) && isSymbolChar(rune(t.Val[i])) {
+				i++
+			}
+		}
+
+		if i > len(t.Val) {
+			i = len(t.Val)
+		}
+
+		name := t.Val[start:i]
+
+		if label, dir, ok := anonReference(name); ok {
+			n := p.anons[label]
+
+			if dir == 'f' {
+				n++
+				p.forward = append(p.forward, anonRef{line, t.Col(start), label, n})
+			} else if n == 0 {
+				return t, errorf(t.Col(start), "no label \"%s\" before this line", label)
+			}
+
+			name = anonLabel(label, n)
+		} else if isLocalLabel(name) {
+			name = p.global + name
+		}
+
+		renamed.Val += name
+
+		for j := 0; j < len(name); j++ {
+			if len(name) == i-start {
+				renamed.Cols = append(renamed.Cols, t.Col(start+j))
+			} else {
+				renamed.Cols = append(renamed.Cols, t.Col(start))
+			}
+		}
+	}
+
+	return renamed, nil
+}
+
+// checkForward reports forward references to anonymous labels which are
+// never defined
+func (p *parser) checkForward() {
+	for _, ref := range p.forward {
+		if p.anons[ref.label] < ref.n {
+			p.errs.add(ref.line, errorf(ref.col, "no label \"%s\" after this line", ref.label))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package hack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocalLabels(t *testing.T) {
+	src := `(F)
+(.loop)
+@.loop
+0;JMP
+(G)
+(.loop)
+@.loop+1
+0;JMP
+@F.loop
+`
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := mustCompile(t, "@0\n0;JMP\n@3\n0;JMP\n@0"); !reflect.DeepEqual(prog.Code, expected) {
+		t.Errorf("Expected %v, have %v", expected, prog.Code)
+	}
+
+	if prog.Symbols["G.loop"] != (Symbol{2, S_LABEL}) {
+		t.Errorf("G.loop should be a label at 2, have %v", prog.Symbols["G.loop"])
+	}
+}
+
+func TestAnonymousLabels(t *testing.T) {
+	src := `.macro WAIT
+(1)
+@1b
+0;JMP
+.endm
+(1)
+@1f
+0;JMP
+WAIT
+(1)
+@1b
+D=A
+@1
+`
+	code := mustCompile(t, src)
+
+	if expected := mustCompile(t, "@2\n0;JMP\n@2\n0;JMP\n@4\nD=A\n@1"); !reflect.DeepEqual(code, expected) {
+		t.Errorf("Expected %v, have %v", expected, code)
+	}
+}
+
+func TestLabelErrors(t *testing.T) {
+	for src, expected := range map[string]string{
+		"(A)\n@1\n(A)\n@2":     "test.asm:3:2: label \"A\" already defined at test.asm:1",
+		"(A)\n(A)\n@2":         "test.asm:2:2: label \"A\" already defined at test.asm:1",
+		"(F)\n(.x)\n(F.x)\n@1": "test.asm:3:2: label \"F.x\" already defined at test.asm:2",
+		"@1b\n(1)\n@1":         "test.asm:1:2: no label \"1\" before this line",
+		"(2)\n@1\n@2f":         "test.asm:3:2: no label \"2\" after this line",
+	} {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 || errs[0].Error() != expected {
+			t.Errorf("%q: expected %q, have %v", src, expected, errs)
+		}
+	}
+}
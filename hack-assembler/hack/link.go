@@ -0,0 +1,190 @@
+package hack
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Link places the code of objects one after another in ROM, allocates
+// their variables from address 16 without collisions and resolves
+// imports to the symbols exported by other objects. The program has no
+// Lines, its Symbols are the predefined and the exported ones.
+func Link(objects ...*Object) (*Program, error) {
+	var errs ErrorList
+
+	l := &linker{
+		prog:   &Program{Symbols: DefaultSymbols()},
+		owners: map[string]int{},
+	}
+
+	for i, obj := range objects {
+		l.place(i, obj, &errs)
+	}
+
+	if len(l.prog.Code) > MAX_VALUE+1 {
+		errs = append(errs, &Error{Msg: fmt.Sprintf("program of %d words doesn't fit in ROM", len(l.prog.Code))})
+	}
+
+	initialized := map[int]string{}
+
+	for i, obj := range objects {
+		l.resolve(i, obj, &errs)
+		l.relocate(i, obj, initialized, &errs)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return l.prog, nil
+}
+
+type linker struct {
+	prog *Program
+	// bases holds the ROM address of every object
+	bases []int
+	// values holds the final value of every symbol of every object
+	values []map[string]int
+	// owners maps exported symbols to the index of their object
+	owners map[string]int
+	// names holds the name of every object
+	names []string
+}
+
+func linkErrorf(obj *Object, format string, args ...interface{}) *Error {
+	return &Error{File: obj.Name, Msg: fmt.Sprintf(format, args...)}
+}
+
+// place appends the code of obj, moves its labels and allocates its
+// variables
+func (l *linker) place(i int, obj *Object, errs *ErrorList) {
+	base := len(l.prog.Code)
+	values := map[string]int{}
+
+	for name, symbol := range obj.Symbols {
+		switch symbol.Kind {
+		case S_LABEL:
+			values[name] = base + int(symbol.Addr)
+		case S_CONSTANT:
+			values[name] = int(symbol.Addr)
+		}
+	}
+
+	next := l.prog.Symbols[VAR]
+
+	for _, name := range obj.variables() {
+		size := obj.Sizes[name]
+
+		if size < 1 {
+			size = 1
+		}
+
+		if int(next.Addr)+size-1 > MAX_VALUE {
+			*errs = append(*errs, linkErrorf(obj, "no room for variable %s of %d words at %d", name, size, next.Addr))
+			continue
+		}
+
+		values[name] = int(next.Addr)
+		next.Addr += uint16(size)
+	}
+
+	l.prog.Symbols[VAR] = next
+
+	for _, name := range obj.Exports {
+		if j, ok := l.owners[name]; ok {
+			*errs = append(*errs, linkErrorf(obj, "duplicate symbol %s, already exported by %s", name, l.names[j]))
+			continue
+		}
+
+		if _, ok := l.prog.Symbols[name]; ok {
+			*errs = append(*errs, linkErrorf(obj, "exported symbol %s is predefined", name))
+			continue
+		}
+
+		if value, ok := values[name]; ok {
+			l.owners[name] = i
+			l.prog.Symbols[name] = Symbol{uint16(value), obj.Symbols[name].Kind}
+		}
+	}
+
+	l.bases = append(l.bases, base)
+	l.values = append(l.values, values)
+	l.prog.Code = append(l.prog.Code, obj.Code...)
+	l.names = append(l.names, obj.Name)
+}
+
+// resolve finds the values of the symbols imported by obj
+func (l *linker) resolve(i int, obj *Object, errs *ErrorList) {
+	var names []string
+
+	for name, symbol := range obj.Symbols {
+		if symbol.Kind == S_IMPORT {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if j, ok := l.owners[name]; ok {
+			l.values[i][name] = l.values[j][name]
+		} else {
+			*errs = append(*errs, linkErrorf(obj, "undefined symbol %s", name))
+		}
+	}
+}
+
+// relocate moves the values of the code words and data blocks of obj
+// which are relative to a symbol
+func (l *linker) relocate(i int, obj *Object, initialized map[int]string, errs *ErrorList) {
+	move := func(value int, name string) (int, bool) {
+		final, ok := l.values[i][name]
+		return value - int(obj.Symbols[name].Addr) + final, ok
+	}
+
+	for _, r := range obj.Relocs {
+		if int(r.Offset) >= len(obj.Code) {
+			*errs = append(*errs, linkErrorf(obj, "relocation of word %d beyond the code", r.Offset))
+			continue
+		}
+
+		addr := l.bases[i] + int(r.Offset)
+		value, ok := move(int(l.prog.Code[addr]), r.Symbol)
+
+		switch {
+		case !ok:
+			continue
+		case value < 0 || value > MAX_VALUE:
+			*errs = append(*errs, linkErrorf(obj, "value %d of word %d relative to %s out of range 0..%d", value, r.Offset, r.Symbol, MAX_VALUE))
+		default:
+			l.prog.Code[addr] = uint16(value)
+		}
+	}
+
+	for _, block := range obj.Data {
+		addr, ok := int(block.Addr), true
+
+		if block.Symbol != "" {
+			addr, ok = move(addr, block.Symbol)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if addr < 0 || addr+len(block.Words)-1 > MAX_VALUE {
+			*errs = append(*errs, linkErrorf(obj, "data at %d relative to %s out of RAM", addr, block.Symbol))
+			continue
+		}
+
+		for j := range block.Words {
+			if other, ok := initialized[addr+j]; ok {
+				*errs = append(*errs, linkErrorf(obj, "RAM[%d] is already initialized by %s", addr+j, other))
+				break
+			}
+			initialized[addr+j] = obj.Name
+		}
+
+		l.prog.Data = append(l.prog.Data, Data{uint16(addr), block.Words})
+	}
+}
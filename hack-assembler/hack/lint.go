@@ -0,0 +1,137 @@
+package hack
+
+import "strings"
+
+// use is a reference to a symbol at a column of a line
+type use struct {
+	line Line
+	col  int
+}
+
+// references collects the uses of symbols in instructions and in the
+// arguments of directives
+func references(prog *Program) map[string][]use {
+	refs := map[string][]use{}
+
+	scan := func(line Line, t Token) {
+		scanSymbols(t.Val, func(start, end int) {
+			name := t.Val[start:end]
+			refs[name] = append(refs[name], use{line, t.Col(start)})
+		})
+	}
+
+	for _, line := range prog.Lines {
+		if line.Tokens[0].Type == T_AINST {
+			scan(line, line.Tokens[0])
+		}
+	}
+
+	for _, line := range prog.Directives {
+		args := line.Tokens[1:]
+
+		switch line.Tokens[0].Val {
+		case ".equ", ".var":
+			args = args[1:]
+		case ".import":
+			args = nil
+		}
+
+		for _, t := range args {
+			scan(line, t)
+		}
+	}
+
+	return refs
+}
+
+// Lint looks for likely mistakes in an assembled program: unreachable
+// code, labels never referenced, variables used only once, writes to M
+// addressed by a ROM label, jumps to the value of a variable and the
+// lack of a loop halting the program. The warnings are sorted by
+// position.
+func Lint(prog *Program) ErrorList {
+	var warns ErrorList
+
+	if len(prog.Lines) == 0 {
+		return nil
+	}
+
+	g := NewCFG(prog)
+	reached := g.Reachable()
+	halts := false
+
+	for i, b := range g.Blocks {
+		if !reached[i] && (i == 0 || reached[i-1]) {
+			warns.add(prog.Lines[b.Start], errorf(0, "unreachable code"))
+		}
+
+		for _, e := range b.Edges {
+			if e.To == i && e.Kind == E_JUMP && reached[i] {
+				halts = true
+			}
+		}
+
+		if b.Target >= 0 {
+			t := prog.Lines[b.Target].Tokens[0]
+
+			scanSymbols(t.Val, func(start, end int) {
+				if name := t.Val[start:end]; prog.Symbols[name].Kind == S_VARIABLE {
+					warns.add(prog.Lines[b.End-1], errorf(0, "jump to the address of variable %s, not to its value", name))
+				}
+			})
+		}
+	}
+
+	if !halts {
+		warns.add(prog.Lines[len(prog.Lines)-1], errorf(0, "program has no terminal halt loop"))
+	}
+
+	refs := references(prog)
+
+	for name, line := range prog.Labels {
+		if len(refs[name]) > 0 || line.Expansion != nil && line.Expansion.Macro != "" {
+			continue
+		}
+
+		if tokens, err := ParseLine(line.Src); err == nil && len(tokens) > 0 {
+			warns.add(line, errorf(tokens[0].Col(0), "label %s is never referenced", tokens[0].Val))
+		}
+	}
+
+	declared := map[string]bool{}
+
+	for _, line := range prog.Directives {
+		if line.Tokens[0].Val == ".var" {
+			declared[line.Tokens[1].Val] = true
+		}
+	}
+
+	for name, symbol := range prog.Symbols {
+		if uses := refs[name]; symbol.Kind == S_VARIABLE && !declared[name] && len(uses) == 1 {
+			warns.add(uses[0].line, errorf(uses[0].col, "variable %s is used only once", name))
+		}
+	}
+
+	for i, line := range prog.Lines[:len(prog.Lines)-1] {
+		if line.Tokens[0].Type != T_AINST {
+			continue
+		}
+
+		dest, _, _ := cInstruction(prog.Lines[i+1].Tokens)
+
+		if !strings.ContainsRune(dest, M_REG) {
+			continue
+		}
+
+		t := line.Tokens[0]
+
+		scanSymbols(t.Val, func(start, end int) {
+			if name := t.Val[start:end]; prog.Symbols[name].Kind == S_LABEL {
+				warns.add(prog.Lines[i+1], errorf(0, "write to RAM at the address of ROM label %s", name))
+			}
+		})
+	}
+
+	warns.Sort()
+	return warns
+}
@@ -0,0 +1,116 @@
+package hack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lint(t *testing.T, src string) []string {
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warns []string
+
+	for _, w := range Lint(prog) {
+		warns = append(warns, w.Error())
+	}
+
+	return warns
+}
+
+func TestLint(t *testing.T) {
+	src := `@counter
+M=1
+(UNUSED)
+@LOOP
+M=0
+(LOOP)
+@i
+M=M+1
+@i
+D=M
+@LOOP
+D;JGT
+@ret
+0;JMP
+@5
+(END)
+@END
+0;JMP
+`
+	expected := []string{
+		"test.asm:1:2: variable counter is used only once",
+		"test.asm:3:2: label UNUSED is never referenced",
+		"test.asm:5: write to RAM at the address of ROM label LOOP",
+		"test.asm:13:2: variable ret is used only once",
+		"test.asm:14: jump to the address of variable ret, not to its value",
+		"test.asm:15: unreachable code",
+		"test.asm:18: program has no terminal halt loop",
+	}
+
+	if warns := lint(t, src); !reflect.DeepEqual(warns, expected) {
+		t.Errorf("Expected:\n%s\nHave:\n%s", strings.Join(expected, "\n"), strings.Join(warns, "\n"))
+	}
+}
+
+func TestLintScopedLabels(t *testing.T) {
+	src := "(MAIN)\n(.loop)\n(.skip)\n(1)\n(2)\n@.loop\nD;JGT\n@2b\nD;JGT\n@MAIN\nD;JLT\nHALT\n"
+	expected := []string{
+		"test.asm:3:2: label .skip is never referenced",
+		"test.asm:4:2: label 1 is never referenced",
+	}
+
+	if warns := lint(t, src); !reflect.DeepEqual(warns, expected) {
+		t.Errorf("Expected %q, have %q", expected, warns)
+	}
+}
+
+func TestLintHaltLoop(t *testing.T) {
+	expected := []string{"test.asm:2: program has no terminal halt loop"}
+
+	if warns := lint(t, "@1\nD=A\n"); !reflect.DeepEqual(warns, expected) {
+		t.Errorf("Expected %q, have %q", expected, warns)
+	}
+
+	if warns := lint(t, "@1\nD=A\nHALT\n"); len(warns) != 0 {
+		t.Errorf("HALT should end the program, have %q", warns)
+	}
+}
+
+func TestLintReturnAddresses(t *testing.T) {
+	src := "@256\nD=A\n@SP\nM=D\nCALL f\nHALT\n(f)\nRET\n"
+
+	if warns := lint(t, src); len(warns) != 0 {
+		t.Errorf("Returns should reach call sites, have %q", warns)
+	}
+}
+
+func TestCFG(t *testing.T) {
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader("@1\nD=A\n(L)\n@L\nD;JGT\nD=0\n(END)\n@END\n0;JMP\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewCFG(prog)
+	var edges [][]Edge
+
+	for _, b := range g.Blocks {
+		edges = append(edges, b.Edges)
+	}
+
+	expected := [][]Edge{
+		{{1, E_FALLTHROUGH}},
+		{{1, E_BRANCH}, {2, E_FALLTHROUGH}},
+		{{3, E_FALLTHROUGH}},
+		{{3, E_JUMP}},
+	}
+
+	if !reflect.DeepEqual(edges, expected) {
+		t.Errorf("Expected %v, have %v", expected, edges)
+	}
+}
@@ -0,0 +1,186 @@
+package hack
+
+import "fmt"
+
+// MAX_MACRO_DEPTH limits how deep macro calls may be nested
+const MAX_MACRO_DEPTH = 16
+
+type macro struct {
+	name   string
+	params []string
+	labels map[string]bool
+	body   []Line
+	def    Line
+}
+
+// Expansion is a macro call or an .include line, Macro is empty for
+// includes. Lines expanded from nested calls refer to the call they come
+// from with Parent.
+type Expansion struct {
+	Macro  string
+	File   string
+	Line   int
+	Parent *Expansion
+}
+
+func (e *Expansion) depth() (n int) {
+	for ; e != nil; e = e.Parent {
+		n++
+	}
+	return
+}
+
+func (e *Expansion) calls(name string) bool {
+	for ; e != nil; e = e.Parent {
+		if e.Macro == name {
+			return true
+		}
+	}
+	return false
+}
+
+// startMacro begins the definition of a macro, its body is recorded
+// until .endm
+func (p *parser) startMacro(line Line) {
+	name := line.Tokens[1]
+
+	if _, ok := p.macros[name.Val]; ok {
+		p.errs.add(line, errorf(name.Col(0), "macro %s already defined", name.Val))
+	}
+
+	p.macro = &macro{name: name.Val, labels: map[string]bool{}, def: line}
+
+	for _, param := range line.Tokens[2:] {
+		for _, prev := range p.macro.params {
+			if prev == param.Val {
+				p.errs.add(line, errorf(param.Col(0), "duplicated parameter %s", param.Val))
+			}
+		}
+
+		p.macro.params = append(p.macro.params, param.Val)
+	}
+}
+
+// record adds line to the body of the macro being defined, reporting
+// false when no macro is being defined
+func (p *parser) record(line Line) bool {
+	if p.macro == nil {
+		return false
+	}
+
+	fields := splitFields(stripComment(line.Src))
+
+	switch {
+	case len(fields) > 0 && fields[0].Val == ".endm":
+		if _, ok := p.macros[p.macro.name]; !ok && p.macro.name != "" {
+			p.macros[p.macro.name] = p.macro
+		}
+		p.macro = nil
+
+	case len(fields) > 0 && fields[0].Val == ".macro":
+		p.errs.add(line, errorf(fields[0].Col(0), "macro definition inside macro %s", p.macro.name))
+
+	default:
+		if tokens, _ := ParseLine(line.Src); len(tokens) > 0 && tokens[0].Type == T_LABEL {
+			p.macro.labels[tokens[0].Val] = true
+		}
+
+		p.macro.body = append(p.macro.body, line)
+	}
+
+	return true
+}
+
+// expand parses the body of the macro or pseudo-instruction called by
+// line, reporting false when line is neither. Parameters are replaced by
+// arguments and labels defined in the body by names unique to the
+// expansion.
+func (p *parser) expand(line Line) bool {
+	fields := splitFields(stripComment(line.Src))
+
+	if len(fields) == 0 {
+		return false
+	}
+
+	m, ok := p.macros[fields[0].Val]
+
+	if !ok {
+		return p.expandPseudo(line, fields)
+	}
+
+	args := fields[1:]
+
+	switch {
+	case len(args) != len(m.params):
+		p.errs.add(line, errorf(fields[0].Col(0), "macro %s expects %d arguments, have %d", m.name, len(m.params), len(args)))
+		return true
+	case line.Expansion.calls(m.name):
+		p.errs.add(line, errorf(fields[0].Col(0), "macro %s calls itself", m.name))
+		return true
+	case line.Expansion.depth() >= MAX_MACRO_DEPTH:
+		p.errs.add(line, errorf(fields[0].Col(0), "macro calls nested deeper than %d levels", MAX_MACRO_DEPTH))
+		return true
+	}
+
+	p.expansions++
+
+	names := map[string]string{}
+
+	for label := range m.labels {
+		names[label] = fmt.Sprintf("%s$%s.%d", m.name, label, p.expansions)
+	}
+
+	for i, param := range m.params {
+		names[param] = args[i].Val
+	}
+
+	call := &Expansion{m.name, line.File, line.Num, line.Expansion}
+
+	for _, body := range m.body {
+		p.line(Line{File: body.File, Num: body.Num, Src: substitute(body.Src, names), Expansion: call})
+	}
+
+	return true
+}
+
+// substitute replaces symbols of src found in names, comments and
+// character literals are kept as is
+func substitute(src string, names map[string]string) string {
+	var result []byte
+
+	for i := 0; i < len(src); {
+		switch ch := src[i]; {
+		case len(src[i:]) >= len(COMMENT) && src[i:i+len(COMMENT)] == COMMENT:
+			return string(append(result, src[i:]...))
+
+		case ch == '\'':
+			end := i + 1
+			for end < len(src) && src[end] != '\'' {
+				end++
+			}
+			if end < len(src) {
+				end++
+			}
+			result = append(result, src[i:end]...)
+			i = end
+
+		case isSymbolChar(rune(ch)):
+			start := i
+			for i < len(src) && isSymbolChar(rune(src[i])) {
+				i++
+			}
+
+			if name, ok := names[src[start:i]]; ok && isSymbol(src[start:i]) {
+				result = append(result, name...)
+			} else {
+				result = append(result, src[start:i]...)
+			}
+
+		default:
+			result = append(result, ch)
+			i++
+		}
+	}
+
+	return string(result)
+}
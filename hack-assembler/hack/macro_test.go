@@ -0,0 +1,141 @@
+package hack
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const pushMacros = `
+.macro PUSHD
+	@SP
+	AM=M+1
+	A=A-1
+	M=D
+.endm
+
+.macro PUSH value
+	@value // push value
+	D=A
+	PUSHD
+.endm
+`
+
+func TestMacroExpansion(t *testing.T) {
+	code := mustCompile(t, pushMacros+"PUSH 5\nPUSH SCREEN+1\n")
+	expected := mustCompile(t, `
+		@5
+		D=A
+		@SP
+		AM=M+1
+		A=A-1
+		M=D
+		@SCREEN+1
+		D=A
+		@SP
+		AM=M+1
+		A=A-1
+		M=D
+	`)
+
+	if !reflect.DeepEqual(code, expected) {
+		t.Errorf("Expected %v, have %v", expected, code)
+	}
+}
+
+func TestMacroLocalLabels(t *testing.T) {
+	src := `
+.macro WAIT addr
+(LOOP)
+	@addr
+	D=M
+	@LOOP
+	D;JEQ
+.endm
+(LOOP)
+WAIT KBD
+WAIT R0
+@LOOP
+0;JMP
+`
+
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case prog.Symbols["LOOP"] != (Symbol{0, S_LABEL}):
+		t.Errorf("Global LOOP should stay at 0, have %v", prog.Symbols["LOOP"])
+	case prog.Symbols["WAIT$LOOP.1"] != (Symbol{0, S_LABEL}):
+		t.Errorf("First expansion label should be at 0, have %v", prog.Symbols["WAIT$LOOP.1"])
+	case prog.Symbols["WAIT$LOOP.2"] != (Symbol{4, S_LABEL}):
+		t.Errorf("Second expansion label should be at 4, have %v", prog.Symbols["WAIT$LOOP.2"])
+	case prog.Code[2] != 0 || prog.Code[6] != 4 || prog.Code[8] != 0:
+		t.Errorf("Jumps should target their own labels, have %v", prog.Code)
+	}
+
+	if file, num := prog.Lines[4].Origin(); file != "test.asm" || num != 11 {
+		t.Errorf("Expanded line should originate from test.asm:11, have %s:%d", file, num)
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	examples := map[string]string{
+		".macro BAD\nD=X\n.endm\nBAD":                     "test.asm:2:3: unexpected comp 'X' (expanded from BAD at test.asm:4)",
+		".macro A1\nD=X\n.endm\n.macro B1\nA1\n.endm\nB1": "test.asm:2:3: unexpected comp 'X' (expanded from A1 at test.asm:5, expanded from B1 at test.asm:7)",
+		".macro P x\n@x\n.endm\nP 1 2":                    "test.asm:4:1: macro P expects 1 arguments, have 2",
+		".macro R\nR\n.endm\nR":                           "test.asm:2:1: macro R calls itself (expanded from R at test.asm:4)",
+		".macro M\n@1":                                    "test.asm:1:1: missing .endm",
+		"@0\n  .macro 1M x\n@x\n.endm":                    "test.asm:2:10: invalid symbol \"1M\"",
+		".endm":                                           "test.asm:1:1: .endm without .macro",
+		".macro M\n.macro N\n.endm":                       "test.asm:2:1: macro definition inside macro M",
+		".macro M x x\n.endm":                             "test.asm:1:12: duplicated parameter x",
+		".macro M\n.endm\n.macro M\n.endm":                "test.asm:3:8: macro M already defined",
+		".macro 1M\n.endm":                                "test.asm:1:8: invalid symbol \"1M\"",
+	}
+
+	for src, expected := range examples {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, have %v", src, errs)
+			continue
+		}
+
+		if errs[0].Error() != expected {
+			t.Errorf("%q: expected %q, have %q", src, expected, errs[0])
+		}
+	}
+}
+
+func TestMacroDepthLimit(t *testing.T) {
+	var src []string
+
+	for i := 0; i <= MAX_MACRO_DEPTH; i++ {
+		src = append(src, fmt.Sprintf(".macro M%d\nM%d\n.endm", i, i+1))
+	}
+
+	src = append(src, fmt.Sprintf(".macro M%d\n@0\n.endm\nM0", MAX_MACRO_DEPTH+1))
+
+	errs := compileErrors(t, strings.Join(src, "\n"))
+
+	if len(errs) != 1 || !strings.Contains(errs[0].Msg, "nested deeper") {
+		t.Errorf("Expected nesting error, have %v", errs)
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	names := map[string]string{"x": "SCREEN+1", "A": "B"}
+	res := substitute("@x // x is 'A'", names)
+
+	if res != "@SCREEN+1 // x is 'A'" {
+		t.Errorf("Unexpected substitution %q", res)
+	}
+
+	if res = substitute("@'A'+A+x1+1x", names); res != "@'A'+B+x1+1x" {
+		t.Errorf("Unexpected substitution %q", res)
+	}
+}
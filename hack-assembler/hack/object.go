@@ -0,0 +1,418 @@
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object is a module assembled on its own, to be linked with others by
+// Link. Its labels are addressed from 0 and its variables from 16, the
+// linker moves both.
+type Object struct {
+	// Name identifies the module in link errors
+	Name string
+	Code []uint16
+	// Relocs lists the code words holding the value of a symbol plus
+	// a constant
+	Relocs []Reloc
+	// Symbols holds the labels, variables, constants and imports of the
+	// module with their module addresses
+	Symbols SymbolTable
+	// Sizes holds the number of words reserved for every variable
+	Sizes map[string]int
+	// Exports are the symbols other modules may import
+	Exports []string
+	Data    []ObjectData
+}
+
+// Reloc marks the code word at Offset as relative to Symbol
+type Reloc struct {
+	Offset uint16
+	Symbol string
+}
+
+// ObjectData is initial RAM data, its address is relative to Symbol
+// unless Symbol is empty
+type ObjectData struct {
+	Data
+	Symbol string
+}
+
+// AssembleObject assembles the source read from r into a relocatable
+// object. Symbols declared with .import are left to the linker, labels,
+// variables and imports can only be used as SYMBOL+OFFSET or
+// SYMBOL-OFFSET.
+func (a *Assembler) AssembleObject(r io.Reader) (*Object, error) {
+	p := a.newParser()
+	p.object = true
+
+	if err := p.parse(a.File, r, nil); err != nil {
+		return nil, err
+	}
+
+	prog, err := p.assemble()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.relocate(a.File, prog)
+}
+
+func isRelocatable(symbol Symbol) bool {
+	return symbol.Kind == S_LABEL || symbol.Kind == S_VARIABLE || symbol.Kind == S_IMPORT
+}
+
+// relocate builds an object from an assembled program, finding the
+// symbol every value is relative to
+func (p *parser) relocate(name string, prog *Program) (*Object, error) {
+	var errs ErrorList
+
+	obj := &Object{Name: name, Code: prog.Code, Symbols: SymbolTable{}, Sizes: map[string]int{}}
+
+	for name, symbol := range prog.Symbols {
+		if symbol.Kind != S_PREDEFINED {
+			obj.Symbols[name] = symbol
+		}
+	}
+
+	vars := obj.variables()
+
+	for i, name := range vars {
+		end := prog.Symbols[VAR].Addr
+
+		if i+1 < len(vars) {
+			end = obj.Symbols[vars[i+1]].Addr
+		}
+
+		obj.Sizes[name] = int(end - obj.Symbols[name].Addr)
+	}
+
+	for i, line := range prog.Lines {
+		if line.Tokens[0].Type != T_AINST {
+			continue
+		}
+
+		symbol, err := relocation(line.Tokens[0], prog.Symbols)
+
+		if err != nil {
+			errs.add(line, err)
+		} else if symbol != "" {
+			obj.Relocs = append(obj.Relocs, Reloc{uint16(i), symbol})
+		}
+	}
+
+	data := prog.Data
+
+	for _, line := range p.directives {
+		args := line.Tokens[1:]
+
+		switch line.Tokens[0].Val {
+		case ".export":
+			for _, t := range args {
+				if symbol, ok := obj.Symbols[t.Val]; !ok || symbol.Kind == S_IMPORT {
+					errs.add(line, errorf(t.Col(0), "exported symbol \"%s\" is not defined", t.Val))
+				} else {
+					obj.Exports = append(obj.Exports, t.Val)
+				}
+			}
+			continue
+		case ".import":
+			continue
+		case ".data":
+			symbol, err := relocation(args[0], prog.Symbols)
+
+			if err != nil {
+				errs.add(line, err)
+			}
+
+			obj.Data = append(obj.Data, ObjectData{data[0], symbol})
+			data = data[1:]
+		}
+
+		for _, t := range args[1:] {
+			if symbol, err := relocation(t, prog.Symbols); err != nil {
+				errs.add(line, err)
+			} else if symbol != "" {
+				errs.add(line, errorf(t.Col(0), "\"%s\" depends on relocatable symbol %s", t.Val, symbol))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, errs
+	}
+
+	return obj, nil
+}
+
+// variables returns the names of the variables of obj by address
+func (obj *Object) variables() (names []string) {
+	for name, symbol := range obj.Symbols {
+		if symbol.Kind == S_VARIABLE {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return obj.Symbols[names[i]].Addr < obj.Symbols[names[j]].Addr
+	})
+
+	return
+}
+
+// relocation returns the relocatable symbol the value of t is relative
+// to, or an empty string for constant values. The symbol must start the
+// expression and be followed only by added or subtracted constants.
+func relocation(t Token, symbols SymbolTable) (string, error) {
+	var name string
+
+	for i := 0; i < len(t.Val); {
+		ch := t.Val[i]
+
+		switch {
+		case ch == '\'':
+			i += 3
+			continue
+		case !isSymbolChar(rune(ch)):
+			i++
+			continue
+		}
+
+		start := i
+
+		for i < len(t.Val) && isSymbolChar(rune(t.Val[i])) {
+			i++
+		}
+
+		symbol, ok := symbols[t.Val[start:i]]
+
+		if ch >= '0' && ch <= '9' || !ok || !isRelocatable(symbol) {
+			continue
+		}
+
+		if name != "" || start > 0 || (i < len(t.Val) && t.Val[i] != '+' && t.Val[i] != '-') {
+			return "", errorf(t.Col(start), "\"%s\" can't be relocated, use SYMBOL+OFFSET", t.Val)
+		}
+
+		name = t.Val[start:i]
+	}
+
+	return name, nil
+}
+
+// WriteObject writes obj as lines of text:
+//
+//	symbol NAME KIND ADDRESS [SIZE]
+//	export NAME
+//	code WORD [SYMBOL]
+//	data ADDRESS SYMBOL|- WORD...
+//
+// with code words in binary, the symbol of a code line marks a relocation
+func WriteObject(w io.Writer, obj *Object) (err error) {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s hack object %s\n", COMMENT, obj.Name)
+
+	for _, name := range sortedNames(obj.Symbols) {
+		symbol := obj.Symbols[name]
+		fmt.Fprintf(bw, "symbol %s %s %d", name, kindNames[symbol.Kind], symbol.Addr)
+
+		if symbol.Kind == S_VARIABLE {
+			fmt.Fprintf(bw, " %d", obj.Sizes[name])
+		}
+		fmt.Fprintln(bw)
+	}
+
+	for _, name := range obj.Exports {
+		fmt.Fprintf(bw, "export %s\n", name)
+	}
+
+	relocs := map[uint16]string{}
+
+	for _, r := range obj.Relocs {
+		relocs[r.Offset] = r.Symbol
+	}
+
+	for i, word := range obj.Code {
+		if symbol, ok := relocs[uint16(i)]; ok {
+			fmt.Fprintf(bw, "code %016b %s\n", word, symbol)
+		} else {
+			fmt.Fprintf(bw, "code %016b\n", word)
+		}
+	}
+
+	for _, block := range obj.Data {
+		symbol := block.Symbol
+
+		if symbol == "" {
+			symbol = "-"
+		}
+
+		fmt.Fprintf(bw, "data %d %s", block.Addr, symbol)
+
+		for _, word := range block.Words {
+			fmt.Fprintf(bw, " %d", word)
+		}
+		fmt.Fprintln(bw)
+	}
+
+	return bw.Flush()
+}
+
+// ReadObject reads an object written by WriteObject, name is used in
+// errors and as the name of the object
+func ReadObject(name string, r io.Reader) (*Object, error) {
+	var errs ErrorList
+
+	obj := &Object{Name: name, Symbols: SymbolTable{}, Sizes: map[string]int{}}
+	scanner := bufio.NewScanner(r)
+
+	for num := 1; scanner.Scan(); num++ {
+		line := Line{File: name, Num: num, Src: scanner.Text()}
+		fields := strings.Fields(stripComment(line.Src))
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := obj.readRecord(fields); err != nil {
+			errs.add(line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return obj, nil
+}
+
+// readRecord adds a line of an object file split into fields to obj
+func (obj *Object) readRecord(fields []string) error {
+	switch fields[0] {
+	case "symbol":
+		if len(fields) < 4 || len(fields) > 5 {
+			return fmt.Errorf("expected symbol NAME KIND ADDRESS [SIZE]")
+		}
+
+		kind, ok := parseKind(fields[2])
+
+		if !ok || kind == S_PREDEFINED {
+			return fmt.Errorf("unknown symbol kind \"%s\"", fields[2])
+		}
+
+		if !isSymbol(fields[1]) {
+			return fmt.Errorf("invalid symbol \"%s\"", fields[1])
+		}
+
+		if _, ok := obj.Symbols[fields[1]]; ok {
+			return fmt.Errorf("duplicated symbol \"%s\"", fields[1])
+		}
+
+		addr, err := strconv.ParseUint(fields[3], 10, 15)
+
+		if err != nil {
+			return fmt.Errorf("address %s out of range 0..%d", fields[3], MAX_VALUE)
+		}
+
+		if kind == S_VARIABLE {
+			size := 1
+
+			if len(fields) == 5 {
+				if size, err = strconv.Atoi(fields[4]); err != nil || size < 1 || size > MAX_VALUE {
+					return fmt.Errorf("invalid size \"%s\"", fields[4])
+				}
+			}
+
+			obj.Sizes[fields[1]] = size
+		} else if len(fields) == 5 {
+			return fmt.Errorf("only variables have a size")
+		}
+
+		obj.Symbols[fields[1]] = Symbol{uint16(addr), kind}
+
+	case "export":
+		if len(fields) != 2 {
+			return fmt.Errorf("expected export NAME")
+		}
+
+		if symbol, ok := obj.Symbols[fields[1]]; !ok || symbol.Kind == S_IMPORT {
+			return fmt.Errorf("exported symbol \"%s\" is not defined", fields[1])
+		}
+
+		obj.Exports = append(obj.Exports, fields[1])
+
+	case "code":
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("expected code WORD [SYMBOL]")
+		}
+
+		word, err := strconv.ParseUint(fields[1], 2, 16)
+
+		if err != nil || len(fields[1]) != 16 {
+			return fmt.Errorf("invalid word \"%s\"", fields[1])
+		}
+
+		if len(fields) == 3 {
+			if err := obj.checkRelocatable(fields[2]); err != nil {
+				return err
+			}
+			obj.Relocs = append(obj.Relocs, Reloc{uint16(len(obj.Code)), fields[2]})
+		}
+
+		obj.Code = append(obj.Code, uint16(word))
+
+	case "data":
+		if len(fields) < 4 {
+			return fmt.Errorf("expected data ADDRESS SYMBOL|- WORD...")
+		}
+
+		addr, err := strconv.ParseUint(fields[1], 10, 15)
+
+		if err != nil {
+			return fmt.Errorf("address %s out of range 0..%d", fields[1], MAX_VALUE)
+		}
+
+		block := ObjectData{Data{Addr: uint16(addr)}, ""}
+
+		if fields[2] != "-" {
+			if err := obj.checkRelocatable(fields[2]); err != nil {
+				return err
+			}
+			block.Symbol = fields[2]
+		}
+
+		for _, field := range fields[3:] {
+			word, err := strconv.ParseUint(field, 10, 16)
+
+			if err != nil {
+				return fmt.Errorf("invalid word \"%s\"", field)
+			}
+
+			block.Words = append(block.Words, uint16(word))
+		}
+
+		obj.Data = append(obj.Data, block)
+
+	default:
+		return fmt.Errorf("unknown record \"%s\"", fields[0])
+	}
+
+	return nil
+}
+
+func (obj *Object) checkRelocatable(name string) error {
+	if symbol, ok := obj.Symbols[name]; !ok || !isRelocatable(symbol) {
+		return fmt.Errorf("relocation of unknown symbol \"%s\"", name)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package hack
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustAssembleObject(t *testing.T, name, src string) *Object {
+	obj, err := New(name).AssembleObject(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return obj
+}
+
+const mainModule = `.import double
+.export x
+@5
+D=A
+@x
+M=D
+@tmp
+M=D
+@double
+0;JMP
+`
+
+const doubleModule = `.import x
+.export double
+.var buf 2
+.data buf+1 7
+(double)
+@x
+D=M
+@tmp
+M=D
+@x
+M=D+M
+(STOP)
+@STOP+1
+0;JMP
+`
+
+func TestLink(t *testing.T) {
+	main := mustAssembleObject(t, "main.asm", mainModule)
+	double := mustAssembleObject(t, "double.asm", doubleModule)
+
+	prog, err := Link(main, double)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// x=16 and tmp=17 of main, buf=18..19 and tmp=20 of double
+	expected := mustCompile(t, `@5
+D=A
+@16
+M=D
+@17
+M=D
+@8
+0;JMP
+@16
+D=M
+@20
+M=D
+@16
+M=D+M
+@15
+0;JMP
+`)
+
+	if !reflect.DeepEqual(prog.Code, expected) {
+		t.Errorf("Expected %v, have %v", expected, prog.Code)
+	}
+
+	if prog.Symbols["double"] != (Symbol{8, S_LABEL}) || prog.Symbols["x"] != (Symbol{16, S_VARIABLE}) {
+		t.Errorf("Expected exported double=8 and x=16, have %v and %v", prog.Symbols["double"], prog.Symbols["x"])
+	}
+
+	if _, ok := prog.Symbols["tmp"]; ok {
+		t.Error("Private variable tmp should not be exported")
+	}
+
+	if ram := prog.RAM(); len(ram) != 20 || ram[19] != 7 {
+		t.Errorf("Expected RAM[19] = 7, have %v", ram)
+	}
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	obj := mustAssembleObject(t, "double.asm", doubleModule)
+	buf := &bytes.Buffer{}
+
+	if err := WriteObject(buf, obj); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ReadObject("double.asm", buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(res, obj) {
+		t.Errorf("Expected %+v, have %+v", obj, res)
+	}
+}
+
+func TestObjectErrors(t *testing.T) {
+	for src, expected := range map[string]string{
+		".import f\n@f*2":                  "\"f*2\" can't be relocated, use SYMBOL+OFFSET",
+		"(L)\n@1+L":                        "\"1+L\" can't be relocated, use SYMBOL+OFFSET",
+		"(L)\n.equ N L+1":                  "\"L+1\" depends on relocatable symbol L",
+		".export nothing\n.import nothing": "exported symbol \"nothing\" is not defined",
+	} {
+		_, err := New("test.asm").AssembleObject(strings.NewReader(src))
+
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, have %v", src, expected, err)
+		}
+	}
+
+	_, err := New("test.asm").Assemble(strings.NewReader(".import f\n@f"))
+
+	if err == nil || !strings.Contains(err.Error(), ".import is only allowed in object files") {
+		t.Errorf("Expected .import error outside objects, have %v", err)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	a := mustAssembleObject(t, "a.asm", ".export f\n.import g\n(f)\n@g\n0;JMP")
+	b := mustAssembleObject(t, "b.asm", ".export f\n(f)\n@f\n0;JMP")
+
+	_, err := Link(a, b)
+	errs, ok := err.(ErrorList)
+
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, have %v", err)
+	}
+
+	for i, expected := range []string{
+		"b.asm: duplicate symbol f, already exported by a.asm",
+		"a.asm: undefined symbol g",
+	} {
+		if errs[i].Error() != expected {
+			t.Errorf("Expected %q, have %q", expected, errs[i].Error())
+		}
+	}
+}
+
+func TestReadObjectErrors(t *testing.T) {
+	for src, expected := range map[string]string{
+		"code 101":                "invalid word \"101\"",
+		"code 0000000000000000 x": "relocation of unknown symbol \"x\"",
+		"symbol x label 5 2":      "only variables have a size",
+		"export y":                "exported symbol \"y\" is not defined",
+		"symbol R0 predefined 0":  "unknown symbol kind \"predefined\"",
+		"stack 256":               "unknown record \"stack\"",
+	} {
+		_, err := ReadObject("test.obj", strings.NewReader(src))
+
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q, have %v", src, expected, err)
+		}
+	}
+}
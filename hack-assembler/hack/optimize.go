@@ -0,0 +1,163 @@
+package hack
+
+import "strings"
+
+const (
+	O_RELOAD      = "redundant A-instruction"
+	O_COPY_BACK   = "copy back of D"
+	O_JUMP_NEXT   = "jump to the next instruction"
+	O_UNREACHABLE = "unreachable instruction"
+)
+
+// Optimization is an instruction removed by the optimizer, Reason is one
+// of the O_ constants
+type Optimization struct {
+	Line   Line
+	Reason string
+}
+
+// optimizeLines removes instructions without effect until none is left:
+// A-instructions loading the value A already holds, M=D after D=M and D=M
+// after M=D, jumps to the next instruction and instructions after an
+// unconditional jump which no label points to. Labels are moved with the
+// instructions, jumps to addresses computed from numbers or label
+// arithmetic are not adjusted.
+func (p *parser) optimizeLines() {
+	for {
+		removed := p.peephole()
+
+		if len(removed) == 0 {
+			return
+		}
+
+		p.remove(removed)
+	}
+}
+
+// cInstruction returns the dest, comp and jump of a C-instruction
+func cInstruction(tokens []Token) (dest, comp, jmp string) {
+	for _, t := range tokens {
+		switch t.Type {
+		case T_DEST:
+			dest = t.Val
+		case T_COMP:
+			comp = t.Val
+		case T_JMP:
+			jmp = t.Val
+		}
+	}
+	return
+}
+
+// peephole finds instructions to remove in one pass over the lines
+func (p *parser) peephole() map[int]string {
+	targets := map[int]bool{}
+
+	for _, symbol := range p.symbols {
+		if symbol.Kind == S_LABEL {
+			targets[int(symbol.Addr)] = true
+		}
+	}
+
+	removed := map[int]string{}
+	// known is the value loaded into A, empty when unknown
+	known := ""
+	reachable := true
+
+	for i, line := range p.lines {
+		if targets[i] {
+			known, reachable = "", true
+		}
+
+		if !reachable {
+			removed[i] = O_UNREACHABLE
+			continue
+		}
+
+		if line.Tokens[0].Type == T_AINST {
+			if line.Tokens[0].Val == known {
+				removed[i] = O_RELOAD
+			}
+			known = line.Tokens[0].Val
+			continue
+		}
+
+		dest, comp, jmp := cInstruction(line.Tokens)
+
+		if i > 0 && !targets[i] && removed[i-1] == "" && jmp == "" && p.lines[i-1].Tokens[0].Type != T_AINST {
+			prevDest, prevComp, prevJmp := cInstruction(p.lines[i-1].Tokens)
+
+			if prevJmp == "" && prevDest+prevComp == comp+dest && (dest == "D" && comp == "M" || dest == "M" && comp == "D") {
+				removed[i] = O_COPY_BACK
+				continue
+			}
+		}
+
+		if strings.ContainsRune(dest, A_REG) {
+			known = ""
+		}
+
+		if jmp == "" {
+			continue
+		}
+
+		if dest == "" && p.jumpsToNext(i) && removed[i-1] == "" {
+			removed[i-1], removed[i] = O_JUMP_NEXT, O_JUMP_NEXT
+			known = ""
+			continue
+		}
+
+		if jmp == JMP {
+			reachable = false
+		}
+	}
+
+	return removed
+}
+
+// jumpsToNext reports whether the jump at i goes to the label of the
+// next instruction, loaded right before it. A must not be used before the
+// next A-instruction, as the load is removed together with the jump.
+func (p *parser) jumpsToNext(i int) bool {
+	if i == 0 || p.lines[i-1].Tokens[0].Type != T_AINST {
+		return false
+	}
+
+	symbol, ok := p.symbols[p.lines[i-1].Tokens[0].Val]
+
+	if !ok || symbol.Kind != S_LABEL || int(symbol.Addr) != i+1 {
+		return false
+	}
+
+	return i+1 == len(p.lines) || p.lines[i+1].Tokens[0].Type == T_AINST
+}
+
+// remove drops the removed lines, moving the following instructions and
+// the labels pointing to them
+func (p *parser) remove(removed map[int]string) {
+	addrs := make([]uint16, len(p.lines)+1)
+	var lines []Line
+
+	for i, line := range p.lines {
+		addrs[i] = uint16(len(lines))
+
+		if reason, ok := removed[i]; ok {
+			p.optimizations = append(p.optimizations, Optimization{line, reason})
+			continue
+		}
+
+		line.Addr = uint16(len(lines))
+		lines = append(lines, line)
+	}
+
+	addrs[len(p.lines)] = uint16(len(lines))
+
+	for name, symbol := range p.symbols {
+		if symbol.Kind == S_LABEL {
+			p.symbols[name] = Symbol{addrs[symbol.Addr], S_LABEL}
+		}
+	}
+
+	p.lines = lines
+	p.lineIndex = uint16(len(lines))
+}
@@ -0,0 +1,59 @@
+package hack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	for src, expected := range map[string]string{
+		"@SP\nM=M+1\n@SP\nA=M":                     "@SP\nM=M+1\nA=M",
+		"@SP\nAM=M-1\n@SP\nD=M":                    "@SP\nAM=M-1\n@SP\nD=M",
+		"@x\nD=M\nM=D\n@y\nM=D\nD=M":               "@x\nD=M\n@y\nM=D",
+		"@x\nD=M\n(L)\nM=D\n@L\n0;JMP":             "@x\nD=M\n(L)\nM=D\n@L\n0;JMP",
+		"@NEXT\n0;JMP\n(NEXT)\n@1\nD=A":            "@1\nD=A",
+		"@NEXT\nD;JGT\n(NEXT)\nM=D":                "@NEXT\nD;JGT\n(NEXT)\nM=D",
+		"@END\n0;JMP\n@1\nD=A\n(END)\n@END\n0;JMP": "(END)\n@END\n0;JMP",
+		"(A)\n@B\n0;JMP\n@1\n(B)\n@A\n0;JMP":       "(A)\n(B)\n@A\n0;JMP",
+	} {
+		a := New("test.asm")
+		a.Optimize = true
+		prog, err := a.AssembleProgram(strings.NewReader(src))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if native := mustCompile(t, expected); !reflect.DeepEqual(prog.Code, native) {
+			t.Errorf("%q: expected %v, have %v", src, native, prog.Code)
+		}
+	}
+}
+
+func TestOptimizationReport(t *testing.T) {
+	src := "@x\nD=M\nM=D\n@END\n0;JMP\n@x\n(END)\n@END\n0;JMP\n"
+	a := New("test.asm")
+	a.Optimize = true
+	prog, err := a.AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report []string
+
+	for _, o := range prog.Optimizations {
+		report = append(report, o.Line.Src+": "+o.Reason)
+	}
+
+	expected := []string{"M=D: " + O_COPY_BACK, "@x: " + O_UNREACHABLE, "@END: " + O_JUMP_NEXT, "0;JMP: " + O_JUMP_NEXT}
+
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected %q, have %q", expected, report)
+	}
+
+	if prog.Symbols["END"].Addr != 2 || prog.Lines[2].Addr != 2 {
+		t.Errorf("END should move to 2, have %d", prog.Symbols["END"].Addr)
+	}
+}
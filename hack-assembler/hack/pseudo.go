@@ -0,0 +1,129 @@
+package hack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pseudo is a built-in instruction expanding into native ones. Operands
+// are separated by whitespace or commas like the arguments of macros.
+type pseudo struct {
+	usage  string
+	args   int
+	expand func(p *parser, args []Token) ([]string, error)
+}
+
+// pseudoInstructions are expanded unless a macro of the same name is
+// defined. The stack grows up from the address in SP, which points to
+// the next free word. CALL pushes the return address keeping D in R13,
+// RET jumps to the address it pops.
+var pseudoInstructions = map[string]pseudo{
+	"GOTO": {"GOTO LABEL", 1, func(p *parser, args []Token) ([]string, error) {
+		return []string{A + args[0].Val, "0;JMP"}, nil
+	}},
+
+	"IFZ": {"IFZ D LABEL", 2, func(p *parser, args []Token) ([]string, error) {
+		if args[0].Val != string(D_REG) {
+			return nil, errorf(args[0].Col(0), "IFZ tests only D, have %s", args[0].Val)
+		}
+		return []string{A + args[1].Val, "D;JEQ"}, nil
+	}},
+
+	"MOV": {"MOV DST,SRC", 2, expandMov},
+
+	"PUSH": {"PUSH D", 1, func(p *parser, args []Token) ([]string, error) {
+		if args[0].Val != string(D_REG) {
+			return nil, errorf(args[0].Col(0), "only D can be pushed, have %s", args[0].Val)
+		}
+		return []string{"@SP", "AM=M+1", "A=A-1", "M=D"}, nil
+	}},
+
+	"POP": {"POP D", 1, func(p *parser, args []Token) ([]string, error) {
+		if args[0].Val != string(D_REG) {
+			return nil, errorf(args[0].Col(0), "only D can be popped, have %s", args[0].Val)
+		}
+		return []string{"@SP", "AM=M-1", "D=M"}, nil
+	}},
+
+	"CALL": {"CALL LABEL", 1, func(p *parser, args []Token) ([]string, error) {
+		ret := fmt.Sprintf("CALL$ret.%d", p.expansions)
+
+		return []string{
+			"@R13", "M=D",
+			A + ret, "D=A",
+			"@SP", "AM=M+1", "A=A-1", "M=D",
+			"@R13", "D=M",
+			A + args[0].Val, "0;JMP",
+			string(LEFT_PAR) + ret + string(RIGHT_PAR),
+		}, nil
+	}},
+
+	"RET": {"RET", 0, func(p *parser, args []Token) ([]string, error) {
+		return []string{"@SP", "AM=M-1", "A=M", "0;JMP"}, nil
+	}},
+
+	"HALT": {"HALT", 0, func(p *parser, args []Token) ([]string, error) {
+		loop := fmt.Sprintf("HALT$%d", p.expansions)
+		return []string{string(LEFT_PAR) + loop + string(RIGHT_PAR), A + loop, "0;JMP"}, nil
+	}},
+}
+
+func isRegister(name string) bool {
+	return len(name) == 1 && strings.ContainsRune("ADM", rune(name[0]))
+}
+
+// expandMov copies between registers and memory words named by symbols.
+// A word is copied to another through D, A can't be stored because
+// addressing the destination overwrites it.
+func expandMov(p *parser, args []Token) ([]string, error) {
+	dst, src := args[0], args[1]
+
+	switch {
+	case dst.Val == src.Val:
+		return nil, errorf(src.Col(0), "MOV to the same place")
+	case isRegister(dst.Val) && isRegister(src.Val):
+		return []string{dst.Val + "=" + src.Val}, nil
+	case dst.Val == "A" || dst.Val == "D":
+		return []string{A + src.Val, dst.Val + "=M"}, nil
+	case src.Val == "D":
+		return []string{A + dst.Val, "M=D"}, nil
+	case !isRegister(dst.Val) && !isRegister(src.Val):
+		return []string{A + src.Val, "D=M", A + dst.Val, "M=D"}, nil
+	}
+
+	return nil, errorf(src.Col(0), "MOV %s,%s needs A to address memory", dst.Val, src.Val)
+}
+
+// expandPseudo expands the pseudo-instruction called by line, reporting
+// false when line is not one
+func (p *parser) expandPseudo(line Line, fields []Token) bool {
+	name := fields[0]
+	ins, ok := pseudoInstructions[name.Val]
+
+	if !ok {
+		return false
+	}
+
+	args := fields[1:]
+
+	if len(args) != ins.args {
+		p.errs.add(line, errorf(name.Col(0), "expected %s", ins.usage))
+		return true
+	}
+
+	p.expansions++
+	lines, err := ins.expand(p, args)
+
+	if err != nil {
+		p.errs.add(line, err)
+		return true
+	}
+
+	call := &Expansion{name.Val, line.File, line.Num, line.Expansion}
+
+	for _, src := range lines {
+		p.line(Line{File: line.File, Num: line.Num, Src: src, Expansion: call})
+	}
+
+	return true
+}
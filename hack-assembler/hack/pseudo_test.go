@@ -0,0 +1,56 @@
+package hack
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPseudoInstructions(t *testing.T) {
+	for src, expected := range map[string]string{
+		"GOTO END\n(END)":  "@END\n0;JMP\n(END)",
+		"IFZ D,END\n(END)": "@END\nD;JEQ\n(END)",
+		"MOV D,A":          "D=A",
+		"MOV A,x":          "@x\nA=M",
+		"MOV x,D":          "@x\nM=D",
+		"MOV x,y":          "@y\nD=M\n@x\nM=D",
+		"PUSH D":           "@SP\nAM=M+1\nA=A-1\nM=D",
+		"POP D":            "@SP\nAM=M-1\nD=M",
+		"RET":              "@SP\nAM=M-1\nA=M\n0;JMP",
+		"HALT":             "(L)\n@L\n0;JMP",
+		"CALL f\n(f)":      "@R13\nM=D\n@R\nD=A\n@SP\nAM=M+1\nA=A-1\nM=D\n@R13\nD=M\n@f\n0;JMP\n(R)\n(f)",
+	} {
+		code := mustCompile(t, src)
+
+		if native := mustCompile(t, expected); !reflect.DeepEqual(code, native) {
+			t.Errorf("%q: expected %v, have %v", src, native, code)
+		}
+	}
+}
+
+func TestPseudoMacroOverride(t *testing.T) {
+	code := mustCompile(t, ".macro HALT\n@42\n.endm\nHALT")
+
+	if !reflect.DeepEqual(code, []uint16{42}) {
+		t.Errorf("A macro should replace HALT, have %v", code)
+	}
+}
+
+func TestPseudoErrors(t *testing.T) {
+	for src, expected := range map[string]string{
+		"GOTO":     "test.asm:1:1: expected GOTO LABEL",
+		"IFZ A,L":  "test.asm:1:5: IFZ tests only D, have A",
+		"PUSH A":   "test.asm:1:6: only D can be pushed, have A",
+		"POP M":    "test.asm:1:5: only D can be popped, have M",
+		"MOV x,A":  "test.asm:1:7: MOV x,A needs A to address memory",
+		"MOV D,D":  "test.asm:1:7: MOV to the same place",
+		"GOTO 1+-": "test.asm:1:5: missing value (expanded from GOTO at test.asm:1)",
+		"RET now":  "test.asm:1:1: expected RET",
+	} {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), expected) {
+			t.Errorf("%q: expected %q, have %v", src, expected, errs)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var kindNames = [...]string{
+	S_PREDEFINED: "predefined",
+	S_LABEL:      "label",
+	S_VARIABLE:   "variable",
+	S_CONSTANT:   "constant",
+	S_IMPORT:     "import",
+}
+
+func parseKind(str string) (int, bool) {
+	for kind, name := range kindNames {
+		if name == str {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+// WriteSymbols writes symbols sorted by address as "NAME KIND ADDRESS"
+// lines, the internal variable counter is skipped
+func WriteSymbols(w io.Writer, symbols SymbolTable) (err error) {
+	if _, err = fmt.Fprintf(w, "%s NAME KIND ADDRESS\n", COMMENT); err != nil {
+		return
+	}
+
+	for _, name := range sortedNames(symbols) {
+		symbol := symbols[name]
+
+		if _, err = fmt.Fprintf(w, "%s %s %d\n", name, kindNames[symbol.Kind], symbol.Addr); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// sortedNames returns the names of symbols ordered by kind, address and
+// name, without the internal variable counter
+func sortedNames(symbols SymbolTable) []string {
+	names := make([]string, 0, len(symbols))
+
+	for name := range symbols {
+		if name != VAR {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, b := symbols[names[i]], symbols[names[j]]
+
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Addr != b.Addr {
+			return a.Addr < b.Addr
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// ReadSymbols reads a symbol file written by WriteSymbols
+func ReadSymbols(name string, r io.Reader) (symbols SymbolTable, err error) {
+	var errs ErrorList
+
+	symbols = SymbolTable{}
+	scanner := bufio.NewScanner(r)
+
+	for num := 1; scanner.Scan(); num++ {
+		line := Line{File: name, Num: num, Src: scanner.Text()}
+		fields := strings.Fields(stripComment(line.Src))
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 3 {
+			errs.add(line, fmt.Errorf("expected NAME KIND ADDRESS"))
+			continue
+		}
+
+		kind, ok := parseKind(fields[1])
+
+		if !ok {
+			errs.add(line, fmt.Errorf("unknown symbol kind \"%s\"", fields[1]))
+			continue
+		}
+
+		if !isSymbol(fields[0]) {
+			errs.add(line, fmt.Errorf("invalid symbol \"%s\"", fields[0]))
+			continue
+		}
+
+		addr, err := strconv.ParseUint(fields[2], 0, 15)
+
+		if err != nil {
+			errs.add(line, fmt.Errorf("address %s out of range 0..%d", fields[2], 1<<15-1))
+			continue
+		}
+
+		if _, ok := symbols[fields[0]]; ok {
+			errs.add(line, fmt.Errorf("duplicated symbol \"%s\"", fields[0]))
+			continue
+		}
+
+		symbols[fields[0]] = Symbol{uint16(addr), kind}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return symbols, errs.Err()
+}
+
+// ImportSymbols adds externals to the predefined symbols. Variables are
+// never allocated at addresses of imported variables.
+func ImportSymbols(predefined, externals SymbolTable) (symbols SymbolTable, err error) {
+	symbols = predefined.Copy()
+
+	for name, symbol := range externals {
+		if old, ok := symbols[name]; ok && old.Addr != symbol.Addr {
+			return nil, fmt.Errorf("imported symbol %s = %d conflicts with %d", name, symbol.Addr, old.Addr)
+		}
+
+		if symbol.Kind == S_VARIABLE && symbol.Addr >= symbols[VAR].Addr {
+			symbols[VAR] = Symbol{symbol.Addr + 1, S_PREDEFINED}
+		}
+
+		symbols[name] = Symbol{symbol.Addr, S_PREDEFINED}
+	}
+
+	return symbols, nil
+}
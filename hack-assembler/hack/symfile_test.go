@@ -0,0 +1,109 @@
+package hack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSymbols(t *testing.T) {
+	prog, err := New("test.asm").AssembleProgram(strings.NewReader("@i\nM=0\n(LOOP)\n@j\n@LOOP\n0;JMP"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symbols := prog.Symbols
+
+	buf := &bytes.Buffer{}
+
+	if err = WriteSymbols(buf, symbols); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	for _, line := range []string{"\nLOOP label 2\n", "\ni variable 16\nj variable 17\n", "\nSCREEN predefined 16384\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in:\n%s", line, out)
+		}
+	}
+
+	if strings.Contains(out, VAR) {
+		t.Errorf("Variable counter should not be written:\n%s", out)
+	}
+
+	res, err := ReadSymbols("test.sym", buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, symbol := range symbols {
+		if name != VAR && res[name] != symbol {
+			t.Errorf("Symbol %s should be read as %v, have %v", name, symbol, res[name])
+		}
+	}
+}
+
+func TestReadSymbolsErrors(t *testing.T) {
+	_, err := ReadSymbols("test.sym", strings.NewReader("A label\nB thing 1\n1C label 2\nD label 40000\nE label 1\nE label 2"))
+
+	errs, ok := err.(ErrorList)
+
+	if !ok {
+		t.Fatalf("Expected ErrorList, have %v", err)
+	}
+
+	expected := []string{
+		"test.sym:1: expected NAME KIND ADDRESS",
+		"test.sym:2: unknown symbol kind \"thing\"",
+		"test.sym:3: invalid symbol \"1C\"",
+		"test.sym:4: address 40000 out of range 0..32767",
+		"test.sym:6: duplicated symbol \"E\"",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, have %v", len(expected), errs)
+	}
+
+	for i := range expected {
+		if errs[i].Error() != expected[i] {
+			t.Errorf("Expected \"%s\", have \"%s\"", expected[i], errs[i])
+		}
+	}
+}
+
+func TestImportSymbols(t *testing.T) {
+	externals := SymbolTable{
+		"shared":  {20, S_VARIABLE},
+		"MULT":    {100, S_LABEL},
+		"counter": {16, S_VARIABLE},
+	}
+
+	predefined, err := ImportSymbols(DefaultSymbols(), externals)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Assembler{File: "test.asm", Symbols: predefined}
+	prog, err := a.AssembleProgram(strings.NewReader("@shared\n@MULT\n@mine"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case prog.Code[0] != 20 || prog.Code[1] != 100:
+		t.Errorf("Imported symbols should keep their addresses, have %v", prog.Code)
+	case prog.Symbols["mine"].Addr != 21:
+		t.Errorf("New variables should follow imported ones, have %d", prog.Symbols["mine"].Addr)
+	case prog.Symbols["shared"].Kind != S_PREDEFINED:
+		t.Errorf("Imported symbols should be predefined")
+	}
+
+	if _, err := ImportSymbols(DefaultSymbols(), SymbolTable{"SCREEN": {1, S_LABEL}}); err == nil {
+		t.Error("Conflicting imported symbol should not be accepted")
+	}
+}
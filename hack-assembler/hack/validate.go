@@ -0,0 +1,152 @@
+package hack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// legalComps lists every comp mnemonic of the Hack instruction set in its
+// canonical form
+var legalComps = []string{
+	"0", "1", "-1",
+	"D", "A", "M",
+	"!D", "!A", "!M",
+	"-D", "-A", "-M",
+	"D+1", "A+1", "M+1",
+	"D-1", "A-1", "M-1",
+	"D+A", "D+M",
+	"D-A", "D-M",
+	"A-D", "M-D",
+	"D&A", "D&M",
+	"D|A", "D|M",
+}
+
+// compAliases are accepted as well, they swap operands of commutative
+// operators
+var compAliases = []string{
+	"A+D", "M+D",
+	"A&D", "M&D",
+	"A|D", "M|D",
+}
+
+var legalDests = []string{
+	"M", "D", "MD", "A", "AM", "AD", "AMD",
+}
+
+var jmpNames = [...]string{
+	"",
+	JGT,
+	JEQ,
+	JGE,
+	JLT,
+	JNE,
+	JLE,
+	JMP,
+}
+
+var legalCompSet = makeSet(legalComps, compAliases)
+
+func makeSet(lists ...[]string) map[string]bool {
+	set := map[string]bool{}
+
+	for _, list := range lists {
+		for _, str := range list {
+			set[str] = true
+		}
+	}
+
+	return set
+}
+
+func isLegalComp(comp string) bool {
+	return legalCompSet[comp]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// editDistance counts insertions, deletions, substitutions and
+// transpositions of adjacent characters needed to turn a into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = min(min(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
+
+// suggest finds the candidate closest to str. Only a single candidate
+// within one edit is suggested, or the upper case form of str.
+func suggest(str string, candidates []string) string {
+	var (
+		best  string
+		count int
+	)
+
+	for _, c := range candidates {
+		if c == strings.ToUpper(str) {
+			return c
+		}
+	}
+
+	for _, c := range candidates {
+		if editDistance(str, c) == 1 {
+			best = c
+			count++
+		}
+	}
+
+	if count == 1 {
+		return best
+	}
+
+	return ""
+}
+
+// suggestComp works as suggest, but also proposes operands in the other
+// order for commutative operators
+func suggestComp(comp string) string {
+	if len(comp) == 3 && isInList(comp[1], PLUS, AND, OR) {
+		swapped := string([]byte{comp[2], comp[1], comp[0]})
+
+		if isLegalComp(swapped) {
+			return swapped
+		}
+	}
+
+	return suggest(comp, legalComps)
+}
+
+// didYouMean appends a suggestion to the error message, if there is one
+func didYouMean(e *Error, suggestion string) *Error {
+	if suggestion != "" {
+		e.Msg = fmt.Sprintf("%s, did you mean \"%s\"?", e.Msg, suggestion)
+	}
+	return e
+}
@@ -0,0 +1,60 @@
+package hack
+
+import "testing"
+
+func TestEditDistance(t *testing.T) {
+	examples := []struct {
+		a, b     string
+		distance int
+	}{
+		{"JMP", "JMP", 0},
+		{"JPM", "JMP", 1},
+		{"JM", "JMP", 1},
+		{"JMPP", "JMP", 1},
+		{"JEZ", "JEQ", 1},
+		{"JGT", "JLE", 2},
+		{"", "AMD", 3},
+	}
+
+	for _, e := range examples {
+		if res := editDistance(e.a, e.b); res != e.distance {
+			t.Errorf("Distance between \"%s\" and \"%s\" should be %d, have %d", e.a, e.b, e.distance, res)
+		}
+	}
+}
+
+func TestInvalidFields(t *testing.T) {
+	examples := map[string]string{
+		"D;JPM":   "unknown jump \"JPM\", did you mean \"JMP\"?",
+		"0;jmp":   "unknown jump \"jmp\", did you mean \"JMP\"?",
+		"D;JXX":   "unknown jump \"JXX\"",
+		"D=1+D":   "invalid comp \"1+D\", did you mean \"D+1\"?",
+		"D=D+a":   "unknown register 'a', did you mean \"D+A\"?",
+		"D=D+D":   "equal operands not allowed",
+		"D=!1":    "invalid comp \"!1\"",
+		"md=D":    "unknown register 'm', did you mean \"MD\"?",
+		"D=D-A-1": "don't know how to handle comp \"D-A-1\"",
+	}
+
+	for src, msg := range examples {
+		errs := compileErrors(t, src)
+
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 error, have %v", src, errs)
+		} else if errs[0].Msg != msg {
+			t.Errorf("%q: expected \"%s\", have \"%s\"", src, msg, errs[0].Msg)
+		}
+	}
+}
+
+func TestCompAliases(t *testing.T) {
+	for _, alias := range compAliases {
+		swapped := string([]byte{alias[2], alias[1], alias[0]})
+		a := mustCompile(t, "D="+alias)
+		b := mustCompile(t, "D="+swapped)
+
+		if a[0] != b[0] {
+			t.Errorf("\"%s\" should compile as \"%s\"", alias, swapped)
+		}
+	}
+}
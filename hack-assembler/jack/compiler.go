@@ -0,0 +1,560 @@
+// Package jack compiles the Jack language into nand2tetris VM code
+package jack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+// bailout is used to unwind the parser after a syntax error
+type bailout struct{}
+
+var binaryOps = map[string]string{
+	"+": "add",
+	"-": "sub",
+	"&": "and",
+	"|": "or",
+	"<": "lt",
+	">": "gt",
+	"=": "eq",
+	"*": "call Math.multiply 2",
+	"/": "call Math.divide 2",
+}
+
+var unaryOps = map[string]string{
+	"-": "neg",
+	"~": "not",
+}
+
+type compiler struct {
+	file   string
+	tokens []token
+	pos    int
+	errs   hack.ErrorList
+
+	w *bufio.Writer
+
+	class      string
+	symbols    *symbolTable
+	subroutine string
+	kind       string
+	labels     int
+}
+
+// Compile compiles the class read from r and writes its VM code to w.
+// Syntax errors stop compilation, other errors are collected in a
+// hack.ErrorList.
+func Compile(file string, r io.Reader, w io.Writer) (err error) {
+	src, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return err
+	}
+
+	tokens, err := tokenize(file, string(src))
+
+	if err != nil {
+		return hack.ErrorList{err.(*hack.Error)}
+	}
+
+	c := &compiler{
+		file:    file,
+		tokens:  tokens,
+		w:       bufio.NewWriter(w),
+		symbols: newSymbolTable(),
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+		}
+
+		if len(c.errs) > 0 {
+			err = c.errs
+		} else {
+			err = c.w.Flush()
+		}
+	}()
+
+	c.compileClass()
+
+	return
+}
+
+func (c *compiler) peek() token {
+	return c.tokens[c.pos]
+}
+
+func (c *compiler) next() token {
+	t := c.tokens[c.pos]
+	if t.kind != EOF {
+		c.pos++
+	}
+	return t
+}
+
+func (c *compiler) errorf(line int, format string, args ...interface{}) {
+	c.errs = append(c.errs, &hack.Error{File: c.file, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// fail reports a syntax error at the current token and stops parsing
+func (c *compiler) fail(expected string) {
+	t := c.peek()
+	c.errorf(t.line, "expected %s, have %s", expected, t)
+	panic(bailout{})
+}
+
+func (c *compiler) is(vals ...string) bool {
+	t := c.peek()
+
+	if t.kind != KEYWORD && t.kind != SYMBOL {
+		return false
+	}
+
+	for _, val := range vals {
+		if t.val == val {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *compiler) expect(val string) token {
+	if !c.is(val) {
+		c.fail(fmt.Sprintf("'%s'", val))
+	}
+	return c.next()
+}
+
+func (c *compiler) identifier() token {
+	if c.peek().kind != IDENTIFIER {
+		c.fail("identifier")
+	}
+	return c.next()
+}
+
+func (c *compiler) typ(allowVoid bool) string {
+	switch {
+	case c.is("int", "char", "boolean"):
+		return c.next().val
+	case allowVoid && c.is("void"):
+		return c.next().val
+	case c.peek().kind == IDENTIFIER:
+		return c.next().val
+	}
+
+	c.fail("type")
+	return ""
+}
+
+func (c *compiler) emit(format string, args ...interface{}) {
+	fmt.Fprintf(c.w, format+"\n", args...)
+}
+
+func (c *compiler) newLabel(prefix string) string {
+	c.labels++
+	return fmt.Sprintf("%s%d", prefix, c.labels)
+}
+
+func (c *compiler) define(name token, typ string, kind int) {
+	if !c.symbols.define(name.val, typ, kind) {
+		c.errorf(name.line, "%s redeclared", name.val)
+	}
+}
+
+func (c *compiler) compileClass() {
+	c.expect("class")
+	c.class = c.identifier().val
+	c.expect("{")
+
+	for c.is("static", "field") {
+		c.compileClassVarDec()
+	}
+
+	for c.is("constructor", "function", "method") {
+		c.compileSubroutine()
+	}
+
+	c.expect("}")
+
+	if c.peek().kind != EOF {
+		c.fail("end of file")
+	}
+}
+
+func (c *compiler) compileClassVarDec() {
+	kind := STATIC
+	if c.next().val == "field" {
+		kind = FIELD
+	}
+
+	typ := c.typ(false)
+
+	for {
+		c.define(c.identifier(), typ, kind)
+
+		if !c.is(",") {
+			break
+		}
+		c.next()
+	}
+
+	c.expect(";")
+}
+
+func (c *compiler) compileSubroutine() {
+	c.kind = c.next().val
+	c.typ(true)
+	c.subroutine = c.identifier().val
+	c.symbols.startSubroutine()
+	c.labels = 0
+
+	if c.kind == "method" {
+		c.symbols.define("this", c.class, ARG)
+	}
+
+	c.expect("(")
+	c.compileParameterList()
+	c.expect(")")
+
+	c.expect("{")
+
+	for c.is("var") {
+		c.compileVarDec()
+	}
+
+	c.emit("function %s.%s %d", c.class, c.subroutine, c.symbols.count(LOCAL))
+
+	switch c.kind {
+	case "constructor":
+		c.emit("push constant %d", c.symbols.count(FIELD))
+		c.emit("call Memory.alloc 1")
+		c.emit("pop pointer 0")
+	case "method":
+		c.emit("push argument 0")
+		c.emit("pop pointer 0")
+	}
+
+	c.compileStatements()
+	c.expect("}")
+}
+
+func (c *compiler) compileParameterList() {
+	if c.is(")") {
+		return
+	}
+
+	for {
+		typ := c.typ(false)
+		c.define(c.identifier(), typ, ARG)
+
+		if !c.is(",") {
+			break
+		}
+		c.next()
+	}
+}
+
+func (c *compiler) compileVarDec() {
+	c.expect("var")
+	typ := c.typ(false)
+
+	for {
+		c.define(c.identifier(), typ, LOCAL)
+
+		if !c.is(",") {
+			break
+		}
+		c.next()
+	}
+
+	c.expect(";")
+}
+
+func (c *compiler) compileStatements() {
+	for {
+		switch {
+		case c.is("let"):
+			c.compileLet()
+		case c.is("if"):
+			c.compileIf()
+		case c.is("while"):
+			c.compileWhile()
+		case c.is("do"):
+			c.compileDo()
+		case c.is("return"):
+			c.compileReturn()
+		case c.is("}"):
+			return
+		default:
+			c.fail("statement")
+		}
+	}
+}
+
+// variable looks up name, reporting undefined variables
+func (c *compiler) variable(name token) symbol {
+	s, ok := c.symbols.lookup(name.val)
+
+	if !ok {
+		c.errorf(name.line, "undefined variable %s", name.val)
+	}
+
+	if ok && s.kind == FIELD && c.kind == "function" {
+		c.errorf(name.line, "field %s used in function %s.%s", name.val, c.class, c.subroutine)
+	}
+
+	return s
+}
+
+func (c *compiler) compileLet() {
+	c.expect("let")
+	name := c.identifier()
+	s := c.variable(name)
+
+	if c.is("[") {
+		c.next()
+		c.emit("push %s %d", segments[s.kind], s.index)
+		c.compileExpression()
+		c.expect("]")
+		c.emit("add")
+
+		c.expect("=")
+		c.compileExpression()
+		c.expect(";")
+
+		c.emit("pop temp 0")
+		c.emit("pop pointer 1")
+		c.emit("push temp 0")
+		c.emit("pop that 0")
+		return
+	}
+
+	c.expect("=")
+	c.compileExpression()
+	c.expect(";")
+
+	c.emit("pop %s %d", segments[s.kind], s.index)
+}
+
+func (c *compiler) compileIf() {
+	elseLabel := c.newLabel("IF_ELSE")
+	endLabel := c.newLabel("IF_END")
+
+	c.expect("if")
+	c.expect("(")
+	c.compileExpression()
+	c.expect(")")
+
+	c.emit("not")
+	c.emit("if-goto %s", elseLabel)
+
+	c.expect("{")
+	c.compileStatements()
+	c.expect("}")
+
+	if !c.is("else") {
+		c.emit("label %s", elseLabel)
+		return
+	}
+
+	c.emit("goto %s", endLabel)
+	c.emit("label %s", elseLabel)
+
+	c.next()
+	c.expect("{")
+	c.compileStatements()
+	c.expect("}")
+
+	c.emit("label %s", endLabel)
+}
+
+func (c *compiler) compileWhile() {
+	loopLabel := c.newLabel("WHILE_LOOP")
+	endLabel := c.newLabel("WHILE_END")
+
+	c.expect("while")
+	c.emit("label %s", loopLabel)
+
+	c.expect("(")
+	c.compileExpression()
+	c.expect(")")
+
+	c.emit("not")
+	c.emit("if-goto %s", endLabel)
+
+	c.expect("{")
+	c.compileStatements()
+	c.expect("}")
+
+	c.emit("goto %s", loopLabel)
+	c.emit("label %s", endLabel)
+}
+
+func (c *compiler) compileDo() {
+	c.expect("do")
+	c.compileSubroutineCall(c.identifier())
+	c.expect(";")
+	c.emit("pop temp 0")
+}
+
+func (c *compiler) compileReturn() {
+	c.expect("return")
+
+	if c.is(";") {
+		c.emit("push constant 0")
+	} else {
+		c.compileExpression()
+	}
+
+	c.expect(";")
+	c.emit("return")
+}
+
+func (c *compiler) compileExpression() {
+	c.compileTerm()
+
+	for c.peek().kind == SYMBOL {
+		op, ok := binaryOps[c.peek().val]
+
+		if !ok {
+			return
+		}
+
+		c.next()
+		c.compileTerm()
+		c.emit(op)
+	}
+}
+
+func (c *compiler) compileTerm() {
+	t := c.peek()
+
+	switch {
+	case t.kind == INT_CONST:
+		c.next()
+		c.emit("push constant %s", t.val)
+
+	case t.kind == STRING_CONST:
+		c.next()
+		c.emit("push constant %d", len(t.val))
+		c.emit("call String.new 1")
+
+		for i := 0; i < len(t.val); i++ {
+			c.emit("push constant %d", t.val[i])
+			c.emit("call String.appendChar 2")
+		}
+
+	case c.is("true"):
+		c.next()
+		c.emit("push constant 0")
+		c.emit("not")
+
+	case c.is("false", "null"):
+		c.next()
+		c.emit("push constant 0")
+
+	case c.is("this"):
+		c.next()
+
+		if c.kind == "function" {
+			c.errorf(t.line, "this used in function %s.%s", c.class, c.subroutine)
+		}
+
+		c.emit("push pointer 0")
+
+	case c.is("("):
+		c.next()
+		c.compileExpression()
+		c.expect(")")
+
+	case c.is("-", "~"):
+		c.next()
+		c.compileTerm()
+		c.emit(unaryOps[t.val])
+
+	case t.kind == IDENTIFIER:
+		c.next()
+
+		switch {
+		case c.is("["):
+			s := c.variable(t)
+			c.next()
+			c.emit("push %s %d", segments[s.kind], s.index)
+			c.compileExpression()
+			c.expect("]")
+			c.emit("add")
+			c.emit("pop pointer 1")
+			c.emit("push that 0")
+
+		case c.is("(", "."):
+			c.compileSubroutineCall(t)
+
+		default:
+			s := c.variable(t)
+			c.emit("push %s %d", segments[s.kind], s.index)
+		}
+
+	default:
+		c.fail("expression")
+	}
+}
+
+// compileSubroutineCall compiles a call, name is already consumed
+func (c *compiler) compileSubroutineCall(name token) {
+	var (
+		function string
+		args     int
+	)
+
+	if c.is(".") {
+		c.next()
+		method := c.identifier().val
+
+		if s, ok := c.symbols.lookup(name.val); ok {
+			c.variable(name)
+			c.emit("push %s %d", segments[s.kind], s.index)
+			function = s.typ + "." + method
+			args++
+		} else {
+			function = name.val + "." + method
+		}
+	} else {
+		if c.kind == "function" {
+			c.errorf(name.line, "method %s called from function %s.%s", name.val, c.class, c.subroutine)
+		}
+
+		c.emit("push pointer 0")
+		function = c.class + "." + name.val
+		args++
+	}
+
+	c.expect("(")
+	args += c.compileExpressionList()
+	c.expect(")")
+
+	c.emit("call %s %d", function, args)
+}
+
+func (c *compiler) compileExpressionList() (n int) {
+	if c.is(")") {
+		return
+	}
+
+	for {
+		c.compileExpression()
+		n++
+
+		if !c.is(",") {
+			return
+		}
+		c.next()
+	}
+}
@@ -0,0 +1,187 @@
+package jack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+func compile(t *testing.T, src string) string {
+	buf := &bytes.Buffer{}
+
+	if err := Compile("Test.jack", strings.NewReader(src), buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func expectVM(t *testing.T, src string, expected ...string) {
+	vm := compile(t, src)
+	want := strings.Join(expected, "\n") + "\n"
+
+	if vm != want {
+		t.Errorf("Expected:\n%s\nHave:\n%s", want, vm)
+	}
+}
+
+func TestCompileFunction(t *testing.T) {
+	expectVM(t, `
+		class Main {
+			static int count;
+
+			function int add(int a, int b) {
+				var int sum;
+				let sum = a + (b * 2);
+				let count = -sum;
+				return sum;
+			}
+		}`,
+		"function Main.add 1",
+		"push argument 0",
+		"push argument 1",
+		"push constant 2",
+		"call Math.multiply 2",
+		"add",
+		"pop local 0",
+		"push local 0",
+		"neg",
+		"pop static 0",
+		"push local 0",
+		"return",
+	)
+}
+
+func TestCompileObjects(t *testing.T) {
+	expectVM(t, `
+		class Point {
+			field int x, y;
+
+			constructor Point new(int ax) {
+				let x = ax;
+				return this;
+			}
+
+			method void move(Point other) {
+				do other.shift(x);
+				do shift(1);
+				return;
+			}
+		}`,
+		"function Point.new 0",
+		"push constant 2",
+		"call Memory.alloc 1",
+		"pop pointer 0",
+		"push argument 0",
+		"pop this 0",
+		"push pointer 0",
+		"return",
+		"function Point.move 0",
+		"push argument 0",
+		"pop pointer 0",
+		"push argument 1",
+		"push this 0",
+		"call Point.shift 2",
+		"pop temp 0",
+		"push pointer 0",
+		"push constant 1",
+		"call Point.shift 2",
+		"pop temp 0",
+		"push constant 0",
+		"return",
+	)
+}
+
+func TestCompileControlFlow(t *testing.T) {
+	expectVM(t, `
+		class Main {
+			function void main() {
+				var Array a;
+				while (~(a[1] = null)) {
+					if (true) { let a[2] = "hi"; } else { do Output.println(); }
+				}
+				return;
+			}
+		}`,
+		"function Main.main 1",
+		"label WHILE_LOOP1",
+		"push local 0",
+		"push constant 1",
+		"add",
+		"pop pointer 1",
+		"push that 0",
+		"push constant 0",
+		"eq",
+		"not",
+		"not",
+		"if-goto WHILE_END2",
+		"push constant 0",
+		"not",
+		"not",
+		"if-goto IF_ELSE3",
+		"push local 0",
+		"push constant 2",
+		"add",
+		"push constant 2",
+		"call String.new 1",
+		"push constant 104",
+		"call String.appendChar 2",
+		"push constant 105",
+		"call String.appendChar 2",
+		"pop temp 0",
+		"pop pointer 1",
+		"push temp 0",
+		"pop that 0",
+		"goto IF_END4",
+		"label IF_ELSE3",
+		"call Output.println 0",
+		"pop temp 0",
+		"label IF_END4",
+		"goto WHILE_LOOP1",
+		"label WHILE_END2",
+		"push constant 0",
+		"return",
+	)
+}
+
+func TestCompileErrors(t *testing.T) {
+	examples := map[string][]string{
+		"class Main {\n function void f() {\n let x = 1;\n return;\n }\n}": {
+			"Test.jack:3: undefined variable x",
+		},
+		"class Main {\n field int x;\n function int f() {\n do g();\n return x;\n }\n}": {
+			"Test.jack:4: method g called from function Main.f",
+			"Test.jack:5: field x used in function Main.f",
+		},
+		"class Main {\n static int x, x;\n}": {
+			"Test.jack:2: x redeclared",
+		},
+		"class Main {\n function void f() {\n let = 1;\n }\n}": {
+			"Test.jack:3: expected identifier, have '='",
+		},
+		"class Main {\n function void f() {\n return\n }\n}": {
+			"Test.jack:4: expected expression, have '}'",
+		},
+		"class Main {": {
+			"Test.jack:1: expected '}', have end of file",
+		},
+	}
+
+	for src, expected := range examples {
+		err := Compile("Test.jack", strings.NewReader(src), &bytes.Buffer{})
+		errs, ok := err.(hack.ErrorList)
+
+		if !ok || len(errs) != len(expected) {
+			t.Errorf("%q: expected %v, have %v", src, expected, err)
+			continue
+		}
+
+		for i := range expected {
+			if errs[i].Error() != expected[i] {
+				t.Errorf("%q: expected \"%s\", have \"%s\"", src, expected[i], errs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package jack
+
+const (
+	STATIC = iota
+	FIELD
+	ARG
+	LOCAL
+)
+
+// segments maps symbol kinds to the VM segments holding them
+var segments = [...]string{
+	STATIC: "static",
+	FIELD:  "this",
+	ARG:    "argument",
+	LOCAL:  "local",
+}
+
+type symbol struct {
+	typ   string
+	kind  int
+	index int
+}
+
+// symbolTable holds class scope (static and field) and subroutine scope
+// (argument and local) symbols
+type symbolTable struct {
+	class      map[string]symbol
+	subroutine map[string]symbol
+	counts     [4]int
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{
+		class:      map[string]symbol{},
+		subroutine: map[string]symbol{},
+	}
+}
+
+// startSubroutine drops the argument and local symbols of the previous
+// subroutine
+func (st *symbolTable) startSubroutine() {
+	st.subroutine = map[string]symbol{}
+	st.counts[ARG] = 0
+	st.counts[LOCAL] = 0
+}
+
+func (st *symbolTable) scope(kind int) map[string]symbol {
+	if kind == STATIC || kind == FIELD {
+		return st.class
+	}
+	return st.subroutine
+}
+
+// define adds a symbol, reporting false when it's already defined in the
+// same scope
+func (st *symbolTable) define(name, typ string, kind int) bool {
+	scope := st.scope(kind)
+
+	if _, ok := scope[name]; ok {
+		return false
+	}
+
+	scope[name] = symbol{typ, kind, st.counts[kind]}
+	st.counts[kind]++
+
+	return true
+}
+
+func (st *symbolTable) count(kind int) int {
+	return st.counts[kind]
+}
+
+func (st *symbolTable) lookup(name string) (symbol, bool) {
+	if s, ok := st.subroutine[name]; ok {
+		return s, true
+	}
+
+	s, ok := st.class[name]
+	return s, ok
+}
@@ -0,0 +1,150 @@
+package jack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+const (
+	KEYWORD = iota
+	SYMBOL
+	INT_CONST
+	STRING_CONST
+	IDENTIFIER
+	EOF
+)
+
+const MAX_INT = 1<<15 - 1
+
+var keywords = map[string]bool{
+	"class":       true,
+	"constructor": true,
+	"function":    true,
+	"method":      true,
+	"field":       true,
+	"static":      true,
+	"var":         true,
+	"int":         true,
+	"char":        true,
+	"boolean":     true,
+	"void":        true,
+	"true":        true,
+	"false":       true,
+	"null":        true,
+	"this":        true,
+	"let":         true,
+	"do":          true,
+	"if":          true,
+	"else":        true,
+	"while":       true,
+	"return":      true,
+}
+
+const symbols = "{}()[].,;+-*/&|<>=~"
+
+type token struct {
+	kind int
+	val  string
+	line int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case EOF:
+		return "end of file"
+	case STRING_CONST:
+		return strconv.Quote(t.val)
+	default:
+		return fmt.Sprintf("'%s'", t.val)
+	}
+}
+
+func isLetter(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// tokenize splits Jack source into tokens, the last one is always EOF
+func tokenize(file, src string) (tokens []token, err error) {
+	line := 1
+
+	for i := 0; i < len(src); {
+		ch := src[i]
+
+		switch {
+		case ch == '\n':
+			line++
+			i++
+
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+
+			if end < 0 {
+				return nil, &hack.Error{File: file, Line: line, Msg: "unterminated comment"}
+			}
+
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+
+		case strings.IndexByte(symbols, ch) >= 0:
+			tokens = append(tokens, token{SYMBOL, string(ch), line})
+			i++
+
+		case ch == '"':
+			end := strings.IndexAny(src[i+1:], "\"\n")
+
+			if end < 0 || src[i+1+end] == '\n' {
+				return nil, &hack.Error{File: file, Line: line, Msg: "unterminated string"}
+			}
+
+			tokens = append(tokens, token{STRING_CONST, src[i+1 : i+1+end], line})
+			i += end + 2
+
+		case isDigit(ch):
+			start := i
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+
+			n, err := strconv.Atoi(src[start:i])
+
+			if err != nil || n > MAX_INT {
+				return nil, &hack.Error{File: file, Line: line, Msg: fmt.Sprintf("integer %s out of range 0..%d", src[start:i], MAX_INT)}
+			}
+
+			tokens = append(tokens, token{INT_CONST, src[start:i], line})
+
+		case isLetter(ch):
+			start := i
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+
+			kind := IDENTIFIER
+			if keywords[src[start:i]] {
+				kind = KEYWORD
+			}
+
+			tokens = append(tokens, token{kind, src[start:i], line})
+
+		default:
+			return nil, &hack.Error{File: file, Line: line, Msg: fmt.Sprintf("unexpected character '%c'", ch)}
+		}
+	}
+
+	return append(tokens, token{EOF, "", line}), nil
+}
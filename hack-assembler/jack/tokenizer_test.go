@@ -0,0 +1,64 @@
+package jack
+
+import "testing"
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize("Test.jack", `
+		/** doc
+		    comment */
+		class Main { // comment
+			let s = "a b"; do x.y(123);
+		}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []token{
+		{KEYWORD, "class", 4},
+		{IDENTIFIER, "Main", 4},
+		{SYMBOL, "{", 4},
+		{KEYWORD, "let", 5},
+		{IDENTIFIER, "s", 5},
+		{SYMBOL, "=", 5},
+		{STRING_CONST, "a b", 5},
+		{SYMBOL, ";", 5},
+		{KEYWORD, "do", 5},
+		{IDENTIFIER, "x", 5},
+		{SYMBOL, ".", 5},
+		{IDENTIFIER, "y", 5},
+		{SYMBOL, "(", 5},
+		{INT_CONST, "123", 5},
+		{SYMBOL, ")", 5},
+		{SYMBOL, ";", 5},
+		{SYMBOL, "}", 6},
+		{EOF, "", 6},
+	}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, have %d: %v", len(expected), len(tokens), tokens)
+	}
+
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("Token %d should be %v, have %v", i, expected[i], tokens[i])
+		}
+	}
+}
+
+func TestTokenizeErrors(t *testing.T) {
+	examples := map[string]string{
+		"let x = 32768;":    "Test.jack:1: integer 32768 out of range 0..32767",
+		"\nlet s = \"abc\n": "Test.jack:2: unterminated string",
+		"/* abc":            "Test.jack:1: unterminated comment",
+		"let x = #;":        "Test.jack:1: unexpected character '#'",
+	}
+
+	for src, msg := range examples {
+		_, err := tokenize("Test.jack", src)
+
+		if err == nil || err.Error() != msg {
+			t.Errorf("%q: expected \"%s\", have %v", src, msg, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// miniOS implements just enough of the Jack OS to run the tests
+const miniOS = `
+function Memory.alloc 0
+push static 0
+push constant 0
+eq
+if-goto INIT
+label ALLOC
+push static 0
+push static 0
+push argument 0
+add
+pop static 0
+return
+label INIT
+push constant 2048
+pop static 0
+goto ALLOC
+
+function Math.multiply 1
+label LOOP
+push argument 1
+push constant 0
+eq
+if-goto END
+push local 0
+push argument 0
+add
+pop local 0
+push argument 1
+push constant 1
+sub
+pop argument 1
+goto LOOP
+label END
+push local 0
+return
+
+function Sys.init 0
+call Main.main 0
+pop temp 0
+label HALT
+goto HALT
+`
+
+func TestJackProgram(t *testing.T) {
+	dir := t.TempDir()
+
+	files := []string{
+		"Main.jack", `
+			class Main {
+				static int result;
+
+				function void main() {
+					var Counter c;
+					var int i;
+					let c = Counter.new(3);
+					while (i < 5) {
+						do c.add(i);
+						let i = i + 1;
+					}
+					let result = c.total();
+					return;
+				}
+			}`,
+		"Counter.jack", `
+			class Counter {
+				field int factor, sum;
+
+				constructor Counter new(int f) {
+					let factor = f;
+					let sum = 0;
+					return this;
+				}
+
+				method void add(int n) {
+					let sum = sum + (n * factor);
+					return;
+				}
+
+				method int total() {
+					return sum;
+				}
+			}`,
+		"Sys.vm", miniOS,
+	}
+
+	var paths []string
+
+	for i := 0; i < len(files); i += 2 {
+		path := filepath.Join(dir, files[i])
+
+		if err := ioutil.WriteFile(path, []byte(files[i+1]), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	sources, err := compileJack(paths)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asm, err := translateVM(sources, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpu := newCPU(mustCompile(t, asm.String()))
+	_, halted := cpu.run(100000)
+
+	if !halted {
+		t.Fatal("Program should halt")
+	}
+
+	// Main.0 is the first static referenced by the program
+	if cpu.RAM[16] != 30 {
+		t.Errorf("Main.result should eq 30, have %d", cpu.RAM[16])
+	}
+}
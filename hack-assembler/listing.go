@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+const LISTING_HEADER = "  ROM  BINARY            HEX    LINE  SOURCE"
+
+// writeListing writes every line of src, the source of the named file,
+// next to the ROM address and encoding of its instruction. Labels are
+// shown at their addresses, instructions expanded from a macro or an
+// included file follow the line calling or including it.
+func writeListing(w io.Writer, name, src string, prog *hack.Program) (err error) {
+	if _, err = fmt.Fprintln(w, LISTING_HEADER); err != nil {
+		return
+	}
+
+	var lines []hack.Line
+
+	for _, line := range prog.Lines {
+		if file, _ := line.Origin(); file == name {
+			lines = append(lines, line)
+		}
+	}
+
+	// labels holds the names labels of the file are defined by, local
+	// and anonymous labels scoped like the assembler does
+	labels := map[int]string{}
+
+	for label, line := range prog.Labels {
+		if line.File == name && line.Expansion == nil {
+			labels[line.Num] = label
+		}
+	}
+
+	code := prog.Code
+	next := 0
+
+	for i, text := range strings.Split(strings.TrimSuffix(src, "\n"), "\n") {
+		num := i + 1
+		text = strings.TrimRight(text, "\r")
+
+		rows := []string{fmt.Sprintf("%5s  %16s  %4s  %5d  %s", "", "", "", num, text)}
+
+		for ; next < len(lines); next++ {
+			if _, origin := lines[next].Origin(); origin != num {
+				break
+			}
+
+			addr := lines[next].Addr
+
+			if lines[next].Expansion == nil {
+				rows[0] = fmt.Sprintf("%5d  %016b  %04X  %5d  %s", addr, code[addr], code[addr], num, text)
+			} else {
+				rows = append(rows, fmt.Sprintf("%5d  %016b  %04X  %5s    %s", addr, code[addr], code[addr], "",
+					strings.TrimSpace(lines[next].Src)))
+			}
+		}
+
+		if symbol, ok := prog.Symbols[labels[num]]; ok && symbol.Kind == hack.S_LABEL {
+			rows[0] = fmt.Sprintf("%5d  %16s  %4s  %5d  %s", symbol.Addr, "", "", num, text)
+		}
+
+		for _, row := range rows {
+			if _, err = fmt.Fprintln(w, strings.TrimRight(row, " ")); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// writeSourceMap writes a "ROM FILE:LINE INSTRUCTION" line for every
+// instruction of prog. Instructions expanded from macros, pseudo-
+// instructions or included files are mapped to the line they originate
+// from.
+func writeSourceMap(w io.Writer, prog *hack.Program) (err error) {
+	if _, err = fmt.Fprintf(w, "%s ROM FILE:LINE INSTRUCTION\n", hack.COMMENT); err != nil {
+		return
+	}
+
+	for _, line := range prog.Lines {
+		file, num := line.Origin()
+		src := line.Src
+
+		if i := strings.Index(src, hack.COMMENT); i >= 0 {
+			src = src[:i]
+		}
+
+		if _, err = fmt.Fprintf(w, "%d\t%s:%d\t%s\n", line.Addr, file, num, strings.TrimSpace(src)); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// sourceLine is the source an instruction comes from, text is the line
+// or the instruction when the line isn't known
+type sourceLine struct {
+	file string
+	num  int
+	text string
+}
+
+// same reports whether both instructions come from the same line
+func (l sourceLine) same(other sourceLine) bool {
+	return l.num > 0 && l.num == other.num && l.file == other.file
+}
+
+// readSourceMap reads a source map written by writeSourceMap
+func readSourceMap(r io.Reader) (map[uint16]sourceLine, error) {
+	lines := map[uint16]sourceLine{}
+	scanner := bufio.NewScanner(r)
+
+	for num := 1; scanner.Scan(); num++ {
+		row := scanner.Text()
+
+		if strings.HasPrefix(row, hack.COMMENT) || strings.TrimSpace(row) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(row, "\t", 3)
+
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected ROM FILE:LINE INSTRUCTION", num)
+		}
+
+		addr, err := strconv.ParseUint(fields[0], 10, 15)
+		sep := strings.LastIndex(fields[1], ":")
+
+		if err != nil || sep < 0 {
+			return nil, fmt.Errorf("line %d: expected ROM FILE:LINE INSTRUCTION", num)
+		}
+
+		line, err := strconv.Atoi(fields[1][sep+1:])
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid line number \"%s\"", num, fields[1][sep+1:])
+		}
+
+		lines[uint16(addr)] = sourceLine{fields[1][:sep], line, fields[2]}
+	}
+
+	return lines, scanner.Err()
+}
+
+// readListing reads the instructions of a listing written by
+// writeListing, expanded instructions belong to the line of the call
+func readListing(r io.Reader) (map[uint16]sourceLine, error) {
+	lines := map[uint16]sourceLine{}
+	scanner := bufio.NewScanner(r)
+	var last sourceLine
+
+	for scanner.Scan() {
+		row := scanner.Text()
+
+		if row == LISTING_HEADER || len(row) < 36 {
+			continue
+		}
+
+		if num, err := strconv.Atoi(strings.TrimSpace(row[29:36])); err == nil {
+			last = sourceLine{"", num, strings.TrimSpace(row[36:])}
+		}
+
+		if row[7] == ' ' {
+			continue
+		}
+
+		addr, err := strconv.ParseUint(strings.TrimSpace(row[:5]), 10, 15)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid listing row \"%s\"", row)
+		}
+
+		lines[uint16(addr)] = last
+	}
+
+	return lines, scanner.Err()
+}
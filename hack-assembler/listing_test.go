@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+func TestWriteListing(t *testing.T) {
+	src := "// sum\n@2 // two\nD=A\n(END)\n\n@END\n0;JMP\n"
+
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = writeListing(buf, "test.asm", src, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		LISTING_HEADER,
+		"                                   1  // sum",
+		"    0  0000000000000010  0002      2  @2 // two",
+		"    1  1110110000010000  EC10      3  D=A",
+		"    2                              4  (END)",
+		"                                   5",
+		"    2  0000000000000010  0002      6  @END",
+		"    3  1110101010000111  EA87      7  0;JMP",
+	}, "\n") + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, buf.String())
+	}
+}
+
+func TestListingScopedLabels(t *testing.T) {
+	src := "@1\n(MAIN)\n(.loop)\nD=D-1\n(1)\n@.loop\nD;JGT\n@1b\n0;JMP\n"
+
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = writeListing(buf, "test.asm", src, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		LISTING_HEADER,
+		"    0  0000000000000001  0001      1  @1",
+		"    1                              2  (MAIN)",
+		"    1                              3  (.loop)",
+		"    1  1110001110010000  E390      4  D=D-1",
+		"    2                              5  (1)",
+		"    2  0000000000000001  0001      6  @.loop",
+		"    3  1110001100000001  E301      7  D;JGT",
+		"    4  0000000000000010  0002      8  @1b",
+		"    5  1110101010000111  EA87      9  0;JMP",
+	}, "\n") + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, buf.String())
+	}
+}
+
+func TestTrailingLabel(t *testing.T) {
+	code := mustCompile(t, "@END\n0;JMP\n(END)")
+
+	if code[0] != 2 {
+		t.Errorf("Label at the end of the program should eq 2, have %d", code[0])
+	}
+}
+
+func TestListingMacro(t *testing.T) {
+	src := ".macro INC x\n(SKIP)\n@x\nM=M+1\n.endm\nINC i\n"
+
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader(src))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = writeListing(buf, "test.asm", src, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		LISTING_HEADER,
+		"                                   1  .macro INC x",
+		"                                   2  (SKIP)",
+		"                                   3  @x",
+		"                                   4  M=M+1",
+		"                                   5  .endm",
+		"                                   6  INC i",
+		"    0  0000000000010000  0010           @i",
+		"    1  1111110111001000  FDC8           M=M+1",
+	}, "\n") + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, buf.String())
+	}
+}
+
+func TestSourceMapPseudo(t *testing.T) {
+	prog, err := hack.New("test.asm").AssembleProgram(strings.NewReader("PUSH D\n\nHALT\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = writeSourceMap(buf, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"// ROM FILE:LINE INSTRUCTION",
+		"0\ttest.asm:1\t@SP",
+		"1\ttest.asm:1\tAM=M+1",
+		"2\ttest.asm:1\tA=A-1",
+		"3\ttest.asm:1\tM=D",
+		"4\ttest.asm:3\t@HALT$2",
+		"5\ttest.asm:3\t0;JMP",
+	}, "\n") + "\n"
+
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nHave:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,381 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+// kindNames describe the symbol kinds in hovers and completions
+var kindNames = [...]string{
+	hack.S_PREDEFINED: "predefined",
+	hack.S_LABEL:      "label",
+	hack.S_VARIABLE:   "variable",
+	hack.S_CONSTANT:   "constant",
+	hack.S_IMPORT:     "import",
+}
+
+// document is an open source file with the last program it assembled to
+type document struct {
+	uri   string
+	path  string
+	lines []string
+	// scopes holds the global label local labels of every line belong to
+	scopes []string
+	// prog is kept from the last version without errors, so symbols can
+	// be looked up while typing
+	prog  *hack.Program
+	diags []Diagnostic
+}
+
+func uriToPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		return u.Path
+	}
+	return uri
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// update replaces the text of the document and assembles it, errors and
+// lint warnings become its diagnostics
+func (d *document) update(text string) {
+	d.lines = strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	d.scopes = make([]string, len(d.lines))
+	d.diags = []Diagnostic{}
+
+	global, macro := "", false
+
+	for i, line := range d.lines {
+		if tokens, _ := hack.ParseLine(line); len(tokens) > 0 {
+			switch t := tokens[0]; {
+			case t.Type == hack.T_DIRECTIVE:
+				macro = t.Val == ".macro" || macro && t.Val != ".endm"
+			case t.Type == hack.T_LABEL && !macro && isGlobal(t.Val):
+				global = t.Val
+			}
+		}
+
+		d.scopes[i] = global
+	}
+
+	prog, err := hack.New(d.path).AssembleProgram(strings.NewReader(text))
+
+	if err != nil {
+		list, ok := err.(hack.ErrorList)
+
+		if !ok {
+			d.diags = append(d.diags, Diagnostic{Severity: SEVERITY_ERROR, Source: "hack", Message: err.Error()})
+			return
+		}
+
+		d.addDiagnostics(list, SEVERITY_ERROR)
+		return
+	}
+
+	d.prog = prog
+	d.addDiagnostics(hack.Lint(prog), SEVERITY_WARNING)
+}
+
+func isGlobal(label string) bool {
+	return !strings.HasPrefix(label, ".") && (label[0] < '0' || label[0] > '9')
+}
+
+// addDiagnostics reports errors of the document, errors in macros and
+// included files are reported at the macro call or .include line
+func (d *document) addDiagnostics(list hack.ErrorList, severity int) {
+	for _, e := range list {
+		file, num, col := e.File, e.Line, e.Column
+
+		for x := e.Expansion; x != nil; x = x.Parent {
+			file, num, col = x.File, x.Line, 0
+		}
+
+		if file != d.path || num < 1 || num > len(d.lines) {
+			continue
+		}
+
+		r := d.lineRange(num - 1)
+
+		if col > 0 {
+			r.Start.Character = col - 1
+
+			if _, symbol, ok := d.symbolAt(Position{num - 1, col - 1}); ok {
+				r = symbol
+			}
+		}
+
+		d.diags = append(d.diags, Diagnostic{Range: r, Severity: severity, Source: "hack", Message: e.Msg})
+	}
+}
+
+// lineRange returns the range of the whole line
+func (d *document) lineRange(line int) Range {
+	return Range{Position{line, 0}, Position{line, len(d.lines[line])}}
+}
+
+// resolve returns the name a symbol written on line refers to, local
+// labels are prefixed by their global label
+func (d *document) resolve(line int, name string) string {
+	if strings.HasPrefix(name, ".") {
+		return d.scopes[line] + name
+	}
+	return name
+}
+
+// symbolAt returns the resolved name and the range of the symbol at pos
+func (d *document) symbolAt(pos Position) (name string, r Range, ok bool) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return "", r, false
+	}
+
+	hack.ScanSymbols(d.lines[pos.Line], func(start, end int) {
+		if start <= pos.Character && pos.Character <= end && !ok {
+			name, ok = d.resolve(pos.Line, d.lines[pos.Line][start:end]), true
+			r = Range{Position{pos.Line, start}, Position{pos.Line, end}}
+		}
+	})
+
+	return
+}
+
+// occurrence is a symbol in the document, def is set where it's defined
+// by a label or a .var or .equ directive
+type occurrence struct {
+	Range
+	def bool
+}
+
+// occurrences finds every occurrence of the resolved name
+func (d *document) occurrences(name string) (found []occurrence) {
+	for i, line := range d.lines {
+		def := d.defines(i, name)
+
+		hack.ScanSymbols(line, func(start, end int) {
+			if d.resolve(i, line[start:end]) == name {
+				found = append(found, occurrence{Range{Position{i, start}, Position{i, end}}, def})
+			}
+		})
+	}
+
+	return
+}
+
+// defines reports whether the line defines the resolved name
+func (d *document) defines(line int, name string) bool {
+	tokens, err := hack.ParseLine(d.lines[line])
+
+	if err != nil || len(tokens) == 0 {
+		return false
+	}
+
+	switch t := tokens[0]; t.Type {
+	case hack.T_LABEL:
+		return d.resolve(line, t.Val) == name
+	case hack.T_DIRECTIVE:
+		return (t.Val == ".var" || t.Val == ".equ") && tokens[1].Val == name
+	}
+
+	return false
+}
+
+// definition returns where the symbol at pos is defined: its label or
+// directive, a label in an included file or the first use of a variable
+// which isn't declared
+func (d *document) definition(pos Position) *Location {
+	name, _, ok := d.symbolAt(pos)
+
+	if !ok {
+		return nil
+	}
+
+	occurrences := d.occurrences(name)
+
+	for _, o := range occurrences {
+		if o.def {
+			return &Location{d.uri, o.Range}
+		}
+	}
+
+	if d.prog == nil {
+		return nil
+	}
+
+	if line, ok := d.prog.Labels[name]; ok {
+		file, num := line.Origin()
+		return &Location{pathToURI(file), Range{Position{num - 1, 0}, Position{num - 1, len(line.Src)}}}
+	}
+
+	if d.prog.Symbols[name].Kind == hack.S_VARIABLE && len(occurrences) > 0 {
+		return &Location{d.uri, occurrences[0].Range}
+	}
+
+	return nil
+}
+
+// references returns the occurrences of the symbol at pos, definitions
+// only when declaration is set
+func (d *document) references(pos Position, declaration bool) []Location {
+	name, _, ok := d.symbolAt(pos)
+	locations := []Location{}
+
+	if !ok {
+		return locations
+	}
+
+	for _, o := range d.occurrences(name) {
+		if declaration || !o.def {
+			locations = append(locations, Location{d.uri, o.Range})
+		}
+	}
+
+	return locations
+}
+
+// describe returns the kind and value of a symbol
+func describe(name string, symbol hack.Symbol) string {
+	switch symbol.Kind {
+	case hack.S_LABEL:
+		return fmt.Sprintf("%s %s: ROM %d (0x%04X)", kindNames[symbol.Kind], name, symbol.Addr, symbol.Addr)
+	case hack.S_VARIABLE, hack.S_PREDEFINED:
+		return fmt.Sprintf("%s %s: RAM %d (0x%04X)", kindNames[symbol.Kind], name, symbol.Addr, symbol.Addr)
+	default:
+		return fmt.Sprintf("%s %s = %d (0x%04X)", kindNames[symbol.Kind], name, symbol.Addr, symbol.Addr)
+	}
+}
+
+// hover describes the symbol at pos and the instructions the line is
+// assembled to with their addresses and encodings
+func (d *document) hover(pos Position) *Hover {
+	if d.prog == nil || pos.Line < 0 || pos.Line >= len(d.lines) {
+		return nil
+	}
+
+	var text []string
+	r := d.lineRange(pos.Line)
+
+	if name, symbol, ok := d.symbolAt(pos); ok {
+		if s, ok := d.prog.Symbols[name]; ok && name != hack.VAR {
+			text = append(text, describe(name, s))
+			r = symbol
+		}
+	}
+
+	for i, line := range d.prog.Lines {
+		if file, num := line.Origin(); file == d.path && num == pos.Line+1 {
+			src := strings.TrimSpace(strings.SplitN(line.Src, hack.COMMENT, 2)[0])
+			text = append(text, fmt.Sprintf("%5d  %016b  %04X  %s", line.Addr, d.prog.Code[i], d.prog.Code[i], src))
+		}
+	}
+
+	if len(text) == 0 {
+		return nil
+	}
+
+	return &Hover{MarkupContent{"markdown", "```\n" + strings.Join(text, "\n") + "\n```"}, r}
+}
+
+// completion lists the symbols of the program, or the predefined ones
+// before it's assembled. Names generated for macros and anonymous labels
+// are left out.
+func (d *document) completion() []CompletionItem {
+	symbols := hack.DefaultSymbols()
+
+	if d.prog != nil {
+		symbols = d.prog.Symbols
+	}
+
+	items := []CompletionItem{}
+
+	for name, symbol := range symbols {
+		if name == hack.VAR || strings.Contains(name, "$") {
+			continue
+		}
+
+		kind := COMPLETION_CONSTANT
+
+		switch symbol.Kind {
+		case hack.S_VARIABLE:
+			kind = COMPLETION_VARIABLE
+		case hack.S_LABEL, hack.S_IMPORT:
+			kind = COMPLETION_REFERENCE
+		}
+
+		items = append(items, CompletionItem{name, kind, describe(name, symbol)})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// symbols lists the labels, variables and constants defined in the
+// document, local labels are children of their global label
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	macro, global := false, -1
+
+	for i, line := range d.lines {
+		tokens, err := hack.ParseLine(line)
+
+		if err != nil || len(tokens) == 0 {
+			continue
+		}
+
+		t := tokens[0]
+		symbol := DocumentSymbol{Range: d.lineRange(i), SelectionRange: d.lineRange(i)}
+
+		if len(t.Cols) > 0 {
+			symbol.SelectionRange = Range{Position{i, t.Col(0) - 1}, Position{i, t.Col(0) - 1 + len(t.Val)}}
+		}
+
+		switch t.Type {
+		case hack.T_DIRECTIVE:
+			macro = t.Val == ".macro" || macro && t.Val != ".endm"
+
+			if t.Val != ".var" && t.Val != ".equ" {
+				continue
+			}
+
+			arg := tokens[1]
+			symbol.Name, symbol.Kind = arg.Val, SYMBOL_CONSTANT
+			symbol.SelectionRange = Range{Position{i, arg.Col(0) - 1}, Position{i, arg.Col(0) - 1 + len(arg.Val)}}
+
+			if t.Val == ".var" {
+				symbol.Kind = SYMBOL_VARIABLE
+			}
+
+		case hack.T_LABEL:
+			if macro || !isGlobal(t.Val) && !strings.HasPrefix(t.Val, ".") {
+				continue
+			}
+
+			symbol.Name, symbol.Kind = t.Val, SYMBOL_FUNCTION
+
+		default:
+			continue
+		}
+
+		if d.prog != nil {
+			if s, ok := d.prog.Symbols[d.resolve(i, symbol.Name)]; ok {
+				symbol.Detail = describe(d.resolve(i, symbol.Name), s)
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(symbol.Name, ".") && global >= 0:
+			symbols[global].Children = append(symbols[global].Children, symbol)
+		case symbol.Kind == SYMBOL_FUNCTION:
+			global = len(symbols)
+			fallthrough
+		default:
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	return symbols
+}
@@ -0,0 +1,194 @@
+// Package lsp serves the Language Server Protocol for Hack assembly:
+// diagnostics, definitions, references, hovers, completion and document
+// symbols
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// Server answers the requests of one client
+type Server struct {
+	r    *bufio.Reader
+	w    io.Writer
+	docs map[string]*document
+}
+
+// Serve reads requests from r and writes responses and notifications to w
+// until the client sends exit or closes r
+func Serve(r io.Reader, w io.Writer) error {
+	s := &Server{r: bufio.NewReader(r), w: w, docs: map[string]*document{}}
+
+	for {
+		req, err := readRequest(s.r)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if e, ok := err.(*responseError); ok {
+			if err = writeMessage(s.w, &response{JSONRPC: "2.0", Error: e}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if req.Method == "exit" {
+			return nil
+		}
+
+		if err = s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs a request and writes its response, notifications get none
+func (s *Server) handle(req *request) error {
+	result, err := s.call(req.Method, req.Params)
+	e, ok := err.(*responseError)
+
+	if err != nil && !ok {
+		return err
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+
+	resp := &response{JSONRPC: "2.0", ID: req.ID}
+
+	if err != nil {
+		resp.Error = e
+	} else {
+		body, err := json.Marshal(result)
+
+		if err != nil {
+			return err
+		}
+
+		raw := json.RawMessage(body)
+		resp.Result = &raw
+	}
+
+	return writeMessage(s.w, resp)
+}
+
+// call dispatches a method to its handler, failures of the request are
+// returned as *responseError
+func (s *Server) call(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       SYNC_FULL,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"@"},
+				},
+			},
+			"serverInfo": map[string]string{"name": "hack-assembler"},
+		}, nil
+
+	case "shutdown":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p didOpenParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &responseError{INVALID_PARAMS, err.Error()}
+		}
+
+		d := &document{uri: p.TextDocument.URI, path: uriToPath(p.TextDocument.URI)}
+		s.docs[d.uri] = d
+		return nil, s.change(d, p.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var p didChangeParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &responseError{INVALID_PARAMS, err.Error()}
+		}
+
+		if d, ok := s.docs[p.TextDocument.URI]; ok && len(p.ContentChanges) > 0 {
+			return nil, s.change(d, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var p didCloseParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &responseError{INVALID_PARAMS, err.Error()}
+		}
+
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.publish(publishDiagnosticsParams{p.TextDocument.URI, []Diagnostic{}})
+
+	case "textDocument/definition", "textDocument/references", "textDocument/hover":
+		var p positionParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &responseError{INVALID_PARAMS, err.Error()}
+		}
+
+		d, ok := s.docs[p.TextDocument.URI]
+
+		switch {
+		case !ok:
+			return nil, nil
+		case method == "textDocument/definition":
+			if loc := d.definition(p.Position); loc != nil {
+				return loc, nil
+			}
+		case method == "textDocument/references":
+			return d.references(p.Position, p.Context.IncludeDeclaration), nil
+		default:
+			if hover := d.hover(p.Position); hover != nil {
+				return hover, nil
+			}
+		}
+		return nil, nil
+
+	case "textDocument/completion", "textDocument/documentSymbol":
+		var p documentParams
+
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &responseError{INVALID_PARAMS, err.Error()}
+		}
+
+		d, ok := s.docs[p.TextDocument.URI]
+
+		switch {
+		case !ok:
+			return nil, nil
+		case method == "textDocument/completion":
+			return d.completion(), nil
+		default:
+			return d.symbols(), nil
+		}
+	}
+
+	return nil, &responseError{METHOD_NOT_FOUND, "method not found: " + method}
+}
+
+// change updates the text of d and publishes its diagnostics
+func (s *Server) change(d *document, text string) error {
+	d.update(text)
+	return s.publish(publishDiagnosticsParams{d.uri, d.diags})
+}
+
+// publish sends the diagnostics of a document to the client
+func (s *Server) publish(params publishDiagnosticsParams) error {
+	return writeMessage(s.w, &notification{"2.0", "textDocument/publishDiagnostics", params})
+}
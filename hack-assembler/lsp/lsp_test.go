@@ -0,0 +1,222 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const URI = "file:///src/test.asm"
+
+// session sends requests numbered from 1, notifications for methods
+// starting with "!", and returns the messages written by the server
+func session(t *testing.T, calls ...interface{}) (msgs []map[string]interface{}) {
+	in := &bytes.Buffer{}
+
+	for i := 0; i < len(calls); i += 2 {
+		method := calls[i].(string)
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": strings.TrimPrefix(method, "!"), "params": calls[i+1]}
+
+		if !strings.HasPrefix(method, "!") {
+			msg["id"] = i/2 + 1
+		}
+
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	out := &bytes.Buffer{}
+
+	if err := Serve(in, out); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(out)
+
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		io.ReadFull(r, body)
+
+		var msg map[string]interface{}
+
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		msgs = append(msgs, msg)
+	}
+}
+
+// result returns the JSON of the result of request id
+func result(t *testing.T, msgs []map[string]interface{}, id int) string {
+	for _, msg := range msgs {
+		if msg["id"] == float64(id) {
+			body, _ := json.Marshal(msg["result"])
+			return string(body)
+		}
+	}
+
+	t.Fatalf("No response to request %d", id)
+	return ""
+}
+
+func open(text string) map[string]interface{} {
+	return map[string]interface{}{"textDocument": map[string]interface{}{"uri": URI, "languageId": "hack", "version": 1, "text": text}}
+}
+
+func at(line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": URI},
+		"position":     map[string]interface{}{"line": line, "character": char},
+		"context":      map[string]interface{}{"includeDeclaration": true},
+	}
+}
+
+func rng(line, start, end int) string {
+	return fmt.Sprintf(`{"end":{"character":%d,"line":%d},"start":{"character":%d,"line":%d}}`, end, line, start, line)
+}
+
+const SRC = `.var count
+(LOOP)
+    @count
+    M=M+1
+(.next)
+    @.next
+    0;JMP`
+
+func TestDiagnostics(t *testing.T) {
+	msgs := session(t,
+		"!textDocument/didOpen", open("@1\nD=X\n"),
+		"!textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": URI, "version": 2},
+			"contentChanges": []interface{}{map[string]interface{}{"text": SRC}},
+		},
+	)
+
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 notifications, have %v", msgs)
+	}
+
+	diags := msgs[0]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	body, _ := json.Marshal(diags[0])
+	expected := `{"message":"unexpected comp 'X'","range":` + rng(1, 2, 3) + `,"severity":1,"source":"hack"}`
+
+	if len(diags) != 1 || string(body) != expected {
+		t.Errorf("Expected %s, have %s", expected, body)
+	}
+
+	body, _ = json.Marshal(msgs[1]["params"])
+	expected = `{"diagnostics":[{"message":"label LOOP is never referenced","range":` + rng(1, 1, 5) + `,"severity":2,"source":"hack"}],"uri":"` + URI + `"}`
+
+	if string(body) != expected {
+		t.Errorf("Expected %s, have %s", expected, body)
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	msgs := session(t,
+		"!textDocument/didOpen", open(SRC),
+		"textDocument/definition", at(2, 6),
+		"textDocument/definition", at(5, 7),
+		"textDocument/references", at(0, 5),
+		"textDocument/definition", at(3, 2),
+		"textDocument/hover", at(5, 6),
+		"textDocument/hover", at(3, 4),
+	)
+
+	tests := map[int]string{
+		2: `{"range":` + rng(0, 5, 10) + `,"uri":"` + URI + `"}`,
+		3: `{"range":` + rng(4, 1, 6) + `,"uri":"` + URI + `"}`,
+		4: `[{"range":` + rng(0, 5, 10) + `,"uri":"` + URI + `"},{"range":` + rng(2, 5, 10) + `,"uri":"` + URI + `"}]`,
+		5: `null`,
+		6: `{"contents":{"kind":"markdown","value":"` + "```" + `\nlabel LOOP.next: ROM 2 (0x0002)\n    2  0000000000000010  0002  @.next\n` + "```" + `"},"range":` + rng(5, 5, 10) + `}`,
+		7: `{"contents":{"kind":"markdown","value":"` + "```" + `\n    1  1111110111001000  FDC8  M=M+1\n` + "```" + `"},"range":` + rng(3, 0, 9) + `}`,
+	}
+
+	for id, expected := range tests {
+		if have := result(t, msgs, id); have != expected {
+			t.Errorf("Expected %s for request %d, have %s", expected, id, have)
+		}
+	}
+}
+
+func TestCompletionAndSymbols(t *testing.T) {
+	msgs := session(t,
+		"initialize", map[string]interface{}{},
+		"!initialized", map[string]interface{}{},
+		"!textDocument/didOpen", open(SRC),
+		"textDocument/completion", at(2, 5),
+		"textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]interface{}{"uri": URI}},
+		"unknown/method", nil,
+		"shutdown", nil,
+		"!exit", nil,
+	)
+
+	var items []CompletionItem
+	json.Unmarshal([]byte(result(t, msgs, 4)), &items)
+	found := map[string]string{}
+
+	for _, item := range items {
+		found[item.Label] = item.Detail
+	}
+
+	for name, detail := range map[string]string{
+		"SCREEN":    "predefined SCREEN: RAM 16384 (0x4000)",
+		"R15":       "predefined R15: RAM 15 (0x000F)",
+		"count":     "variable count: RAM 16 (0x0010)",
+		"LOOP.next": "label LOOP.next: ROM 2 (0x0002)",
+	} {
+		if found[name] != detail {
+			t.Errorf("Expected completion %s with %q, have %q", name, detail, found[name])
+		}
+	}
+
+	var symbols []DocumentSymbol
+	json.Unmarshal([]byte(result(t, msgs, 5)), &symbols)
+	var names []string
+
+	for _, s := range symbols {
+		names = append(names, fmt.Sprintf("%s %d %d", s.Name, s.Kind, len(s.Children)))
+	}
+
+	if expected := []string{"count 13 0", "LOOP 12 1"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected symbols %v, have %v", expected, names)
+	}
+
+	if have := result(t, msgs, 7); have != "null" {
+		t.Errorf("Expected null result of shutdown, have %s", have)
+	}
+
+	for _, msg := range msgs {
+		if msg["id"] == float64(6) && msg["error"].(map[string]interface{})["code"] != float64(METHOD_NOT_FOUND) {
+			t.Errorf("Expected method not found, have %v", msg)
+		}
+	}
+}
+
+func TestBadContentLength(t *testing.T) {
+	for _, length := range []string{"-1", "x", strconv.Itoa(MAX_CONTENT_LENGTH + 1)} {
+		in := strings.NewReader("Content-Length: " + length + "\r\n\r\n{}")
+		expected := fmt.Sprintf("invalid Content-Length \"%s\"", length)
+
+		if err := Serve(in, &bytes.Buffer{}); err == nil || err.Error() != expected {
+			t.Errorf("Expected %s, have %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+const (
+	PARSE_ERROR      = -32700
+	METHOD_NOT_FOUND = -32601
+	INVALID_PARAMS   = -32602
+
+	SEVERITY_ERROR   = 1
+	SEVERITY_WARNING = 2
+
+	// SYNC_FULL makes clients send the whole text on every change
+	SYNC_FULL = 1
+
+	COMPLETION_VARIABLE  = 6
+	COMPLETION_REFERENCE = 18
+	COMPLETION_CONSTANT  = 21
+
+	SYMBOL_FUNCTION = 12
+	SYMBOL_VARIABLE = 13
+	SYMBOL_CONSTANT = 14
+
+	// MAX_CONTENT_LENGTH is the longest message body accepted
+	MAX_CONTENT_LENGTH = 1 << 24
+)
+
+// request is a JSON-RPC request, or a notification when it has no ID
+type request struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+// response answers the request with the same ID by a result or an error
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ReadMessage reads the body of a message framed by a Content-Length
+// header, which the Debug Adapter Protocol uses too
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+
+	if err != nil || length < 0 || length > MAX_CONTENT_LENGTH {
+		return nil, fmt.Errorf("invalid Content-Length \"%s\"", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// readRequest reads a request framed by a Content-Length header
+func readRequest(r *bufio.Reader) (*request, error) {
+	body, err := ReadMessage(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{}
+
+	if err = json.Unmarshal(body, req); err != nil {
+		return nil, &responseError{PARSE_ERROR, err.Error()}
+	}
+
+	return req, nil
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// writeMessage writes a response or notification framed by a
+// Content-Length header
+func writeMessage(w io.Writer, msg interface{}) error {
+	body, err := json.Marshal(msg)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+	return err
+}
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type TextDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type didOpenParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   TextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// positionParams are the parameters of requests about a position in a
+// document, Context is only sent with references
+type positionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	Context      struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type documentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    Range         `json:"range"`
+}
+
+type CompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
@@ -0,0 +1,826 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+	"github.com/mluts/learning-go/hack-assembler/lsp"
+)
+
+func showUsage() {
+	fmt.Printf(`
+	USAGE:
+
+	%[1]s [-O] [-format F] [-I DIR] [-l LISTING-FILE] [-map MAP-FILE] [-cfg DOT-FILE] [-sym SYM-FILE] [-import SYM-FILE] [-ram RAM-FILE] ASSEMBLY-FILE... OUTPUT-FILE
+	%[1]s -c [-I DIR] [-import SYM-FILE] ASSEMBLY-FILE OBJECT-FILE
+	%[1]s link [-format F] [-sym SYM-FILE] [-ram RAM-FILE] OBJECT-FILE... OUTPUT-FILE
+	%[1]s lint [-I DIR] ASSEMBLY-FILE...
+	%[1]s fmt [-w | -check] ASSEMBLY-FILE...
+	%[1]s lsp
+	%[1]s run [-format F] [-cycles N] [-dump RANGES] [-ram RAM-FILE] FILE
+	%[1]s debug [-format F] [-cycles N] [-ram RAM-FILE] [-sym SYM-FILE] [-map MAP-FILE | -l LISTING-FILE] FILE
+	%[1]s gdb [-addr HOST:PORT] [-format F] [-cycles N] [-ram RAM-FILE] [-sym SYM-FILE] [-map MAP-FILE | -l LISTING-FILE] FILE
+	%[1]s dap
+	%[1]s disasm [-format F] HACK-FILE [OUTPUT-FILE]
+	%[1]s vm [-bootstrap] [-O] [-format F] [-o OUTPUT-FILE] VM-FILE|DIR...
+	%[1]s jack [-O] [-format F] [-o OUTPUT-FILE] JACK-FILE|VM-FILE|DIR...
+
+	Compiles HACK-ASSEMBLY to HACK machine code, optionally writing
+	a listing with the ROM address and encoding of every source line,
+	a source map with the file and line every instruction comes from,
+	the control flow graph of basic blocks in Graphviz DOT and the
+	symbol table. Several ASSEMBLY-FILEs are assembled into
+	one program sharing symbols. Included files are searched next to
+	the including file, then in every -I DIR. Symbols of -import are predefined, so
+	separately assembled routines can share addresses. The RAM
+	contents defined by .data directives are written to RAM-FILE
+
+	The pseudo-instructions GOTO LABEL, IFZ D LABEL, MOV DST,SRC,
+	PUSH D, POP D, CALL LABEL, RET and HALT expand into native
+	instructions. CALL pushes the return address on the stack at SP
+	keeping D in R13, RET pops it and jumps there
+
+	-O removes redundant A-instructions, D=M/M=D copies back, jumps to
+	the next instruction and unreachable code, then prints the words
+	saved. Jumps must go to labels, computed addresses aren't adjusted
+
+	With -c a single module is assembled into a relocatable object.
+	Symbols of other modules are declared with .import NAME, the ones
+	other modules may use with .export NAME
+
+	link - links objects into one program: their code is placed in the
+	       given order, imports are resolved to exports and variables of
+	       all modules are allocated from address 16
+
+	Machine code formats (F) are %[2]s.
+	By default the format is chosen by file extension: .hack, .bin,
+	.hex, .rom (logisim), .coe and .mif
+
+	lint - warns about unreachable code, labels never referenced,
+	       variables used only once, writes to M addressed by a label,
+	       jumps to the address of a variable and programs which never
+	       reach a halt loop, exiting with 1 when there are warnings
+
+	fmt - formats assembly: labels and directives at the left margin,
+	      instructions indented, whitespace removed from instructions,
+	      commutative comps as D+A and trailing comments aligned. The
+	      result is printed, with -w it replaces the file and with
+	      -check the unformatted files are listed, exiting with 1
+
+	lsp - runs a Language Server Protocol server on stdin and stdout for
+	      editors: diagnostics, go to definition, references, hover with
+	      addresses and encodings, completion and document symbols
+
+	run - executes FILE (assembly or .hack machine code) on the HACK CPU
+	      for N cycles or until the program halts, then prints RAM RANGES
+	      like "0-15,256,SCREEN-16415". RAM is initialized from
+	      RAM-FILE or, for assembly, from its .data directives
+
+	debug - runs FILE in an interactive debugger: step, next and continue,
+	        breakpoints on ROM addresses, labels or FILE:LINE, watchpoints
+	        on RAM words and registers and RAM shown by symbol. Machine
+	        code gets its symbols from SYM-FILE and its source from a
+	        MAP-FILE or LISTING-FILE, assembly from the assembler. Type
+	        help for the commands
+
+	gdb - serves FILE to a debugger speaking the GDB remote protocol,
+	      like "target remote localhost:1234" in GDB, on HOST:PORT
+	      (localhost:1234 by default). Registers are a, d and pc, memory
+	      is byte addressed with 16-bit little-endian words: ROM at 0
+	      and RAM at 0x10000, so pc and breakpoints are twice the ROM
+	      address. Breakpoints, write watchpoints, step, continue and
+	      interrupts are supported and the program exits when it halts
+
+	dap - runs a Debug Adapter Protocol server on stdin and stdout for
+	      editors. Launch takes the program (assembly or machine code),
+	      stopOnEntry and cycles, a limit for one continue. Breakpoints
+	      are set on source lines, stepping in and out follows CALL and
+	      RET, and the variables show A, D, PC, the variables of the
+	      program and SP, LCL, ARG, THIS and THAT
+
+	disasm - decodes HACK machine code back to assembly, printing it to
+	         OUTPUT-FILE or stdout. Words the assembler can't encode are
+	         listed and nothing is written, exiting with 1
+
+	vm - translates VM code to assembly, or to machine code when
+	     OUTPUT-FILE has a machine code extension or -format is given.
+	     With -bootstrap the program starts by calling Sys.init
+
+	jack - compiles Jack classes to VM code, writing CLASS.vm next to
+	       each CLASS.jack. With OUTPUT-FILE the classes are linked with
+	       the given VM files (like the OS) into a single assembly or
+	       machine code program starting at Sys.init
+`, os.Args[0], formatNames())
+	os.Exit(1)
+}
+
+// loadCode reads machine code from path in the given format or the one
+// matching its extension, other files are assembled and their initial
+// RAM is returned too
+func loadCode(path, format string) (code, ram []uint16, err error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	if format != "" {
+		f, err := codeFormat(format, path)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		code, err = f.Read(file)
+		return code, nil, err
+	}
+
+	if f, ok := findFormat("", path); ok {
+		code, err = f.Read(file)
+		return code, nil, err
+	}
+
+	prog, err := hack.New(path).AssembleProgram(file)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return prog.Code, prog.RAM(), nil
+}
+
+// loadRAM reads a RAM image in the format matching the extension of path
+func loadRAM(path string) ([]uint16, error) {
+	f, err := codeFormat("", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return f.Read(file)
+}
+
+// pathList is a flag collecting every value given
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, string(os.PathListSeparator))
+}
+
+func (l *pathList) Set(path string) error {
+	*l = append(*l, path)
+	return nil
+}
+
+// readSymbolFile reads the symbol file at path
+func readSymbolFile(path string) (hack.SymbolTable, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return hack.ReadSymbols(path, file)
+}
+
+// loadSymbols reads the symbol file at path and imports its symbols
+// into the default ones
+func loadSymbols(path string) (hack.SymbolTable, error) {
+	externals, err := readSymbolFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hack.ImportSymbols(hack.DefaultSymbols(), externals)
+}
+
+// readSourceFile reads the source map at path, or the listing when
+// listing is set
+func readSourceFile(path string, listing bool) (map[uint16]sourceLine, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if listing {
+		return readListing(file)
+	}
+
+	return readSourceMap(file)
+}
+
+func runCommand(args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	cycles := flags.Int("cycles", 1000000, "maximum number of cycles to execute")
+	dump := flags.String("dump", "", "RAM ranges to print after execution")
+	format := flags.String("format", "", "machine code format of FILE")
+	ramFile := flags.String("ram", "", "initialize RAM from FILE")
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		showUsage()
+	}
+
+	ranges, err := parseMemRanges(*dump)
+
+	if err != nil {
+		fmt.Printf("Can't parse -dump: %v\n", err)
+		showUsage()
+	}
+
+	code, ram, err := loadCode(flags.Arg(0), *format)
+
+	if err == nil && *ramFile != "" {
+		ram, err = loadRAM(*ramFile)
+	}
+
+	if err != nil {
+		printErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	cpu := newCPU(code)
+	cpu.loadRAM(ram)
+	n, halted := cpu.run(*cycles)
+
+	if halted {
+		fmt.Printf("Halted after %d cycles, PC=%d\n", n, cpu.PC)
+	} else {
+		fmt.Printf("Stopped after %d cycles, PC=%d\n", n, cpu.PC)
+	}
+
+	cpu.dumpRAM(os.Stdout, ranges)
+}
+
+// debugFlags defines the flags of the debug and gdb commands, the returned
+// function loads the debugger for FILE once they're parsed
+func debugFlags(flags *flag.FlagSet) func() *debugger {
+	cycles := flags.Int("cycles", 1000000, "maximum number of cycles of one continue")
+	format := flags.String("format", "", "machine code format of FILE")
+	ramFile := flags.String("ram", "", "initialize RAM from FILE")
+	symFile := flags.String("sym", "", "read symbols of machine code from FILE")
+	sourceMap := flags.String("map", "", "read the source map of machine code from FILE")
+	listing := flags.String("l", "", "read the listing of machine code from FILE")
+
+	return func() *debugger {
+		if flags.NArg() != 1 || *sourceMap != "" && *listing != "" || *cycles < 1 {
+			showUsage()
+		}
+
+		d, err := loadDebugger(flags.Arg(0), *format, *ramFile, *symFile, *sourceMap, *listing)
+
+		if err != nil {
+			printErrors(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		d.cycles = *cycles
+		return d
+	}
+}
+
+// loadDebugger loads the program of path with its RAM, symbols and
+// source
+func loadDebugger(path, format, ramFile, symFile, sourceMap, listing string) (*debugger, error) {
+	var (
+		symbols hack.SymbolTable
+		source  map[uint16]sourceLine
+		code    []uint16
+		ram     []uint16
+		err     error
+	)
+
+	if _, ok := findFormat(format, path); ok {
+		code, ram, err = loadCode(path, format)
+	} else {
+		var prog *hack.Program
+
+		if prog, err = hack.New(path).AssembleFiles(path); err == nil {
+			code, ram, symbols, source = prog.Code, prog.RAM(), prog.Symbols, programSource(prog)
+		}
+	}
+
+	if err == nil && ramFile != "" {
+		ram, err = loadRAM(ramFile)
+	}
+
+	if err == nil && symFile != "" {
+		var externals hack.SymbolTable
+
+		if externals, err = readSymbolFile(symFile); err == nil {
+			symbols = hack.DefaultSymbols()
+
+			for name, symbol := range externals {
+				symbols[name] = symbol
+			}
+		}
+	}
+
+	switch {
+	case err != nil:
+	case sourceMap != "":
+		source, err = readSourceFile(sourceMap, false)
+	case listing != "":
+		source, err = readSourceFile(listing, true)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newDebugger(code, ram, symbols, source), nil
+}
+
+func debugCommand(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	load := debugFlags(flags)
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	load().repl(os.Stdin, os.Stdout)
+}
+
+func gdbCommand(args []string) {
+	flags := flag.NewFlagSet("gdb", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:1234", "listen on HOST:PORT")
+	load := debugFlags(flags)
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	d := load()
+	l, err := net.Listen("tcp", *addr)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "Waiting for GDB on %s\n", l.Addr())
+
+	conn, err := l.Accept()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	defer conn.Close()
+
+	if err = d.serveGDB(conn); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func disasmCommand(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	format := flags.String("format", "", "machine code format of HACK-FILE")
+	flags.Usage = showUsage
+	flags.Parse(args)
+	args = flags.Args()
+
+	if len(args) < 1 || len(args) > 2 {
+		showUsage()
+	}
+
+	f, err := codeFormat(*format, args[0])
+
+	if err != nil {
+		fmt.Println(err)
+		showUsage()
+	}
+
+	inputFile, err := os.Open(args[0])
+
+	if err != nil {
+		fmt.Printf("Can't open file for reading %s: %v", args[0], err)
+		showUsage()
+	}
+
+	code, err := f.Read(inputFile)
+
+	if err != nil {
+		fmt.Printf("Can't read code from %s: %v", args[0], err)
+		showUsage()
+	}
+
+	lines, illegal := hack.Disassemble(code)
+
+	for _, addr := range illegal {
+		fmt.Fprintf(os.Stderr, "%s: word %d: illegal instruction %016b\n", args[0], addr, code[addr])
+	}
+
+	if len(illegal) > 0 {
+		os.Exit(1)
+	}
+
+	var output io.Writer = os.Stdout
+
+	if len(args) == 2 {
+		outputFile, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+
+		if err != nil {
+			fmt.Printf("Can't open file for writing %s: %v", args[1], err)
+			showUsage()
+		}
+		defer outputFile.Close()
+
+		output = outputFile
+	}
+
+	if err = writeDisassembly(output, lines); err != nil {
+		fmt.Printf("Can't write assembly: %v", err)
+		showUsage()
+	}
+}
+
+func writeDisassembly(w io.Writer, lines []string) (err error) {
+	for _, line := range lines {
+		if _, err = fmt.Fprintln(w, line); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// writeSymbolFile writes the symbols of prog to path unless it is empty
+func writeSymbolFile(path string, prog *hack.Program) {
+	if path == "" {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err := hack.WriteSymbols(buf, prog.Symbols); err != nil {
+		fmt.Printf("Can't write symbols: %v", err)
+		showUsage()
+	}
+
+	writeOutput(path, buf, false)
+}
+
+// writeRAMFile writes the initial RAM of prog to path unless it is empty
+func writeRAMFile(path, format string, prog *hack.Program) {
+	if path == "" {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	f, err := codeFormat(format, path)
+
+	if err == nil {
+		err = f.Write(buf, prog.RAM())
+	}
+
+	if err != nil {
+		fmt.Printf("Can't write RAM: %v", err)
+		showUsage()
+	}
+
+	writeOutput(path, buf, false)
+}
+
+// compileObject assembles the module at input into an object file
+func compileObject(a *hack.Assembler, input, output string) {
+	file, err := os.Open(input)
+
+	if err != nil {
+		fmt.Printf("Can't open file for reading %s: %v", input, err)
+		showUsage()
+	}
+	defer file.Close()
+
+	a.File = input
+	obj, err := a.AssembleObject(file)
+
+	if err != nil {
+		printErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = hack.WriteObject(buf, obj); err != nil {
+		fmt.Printf("Can't write object: %v", err)
+		showUsage()
+	}
+
+	writeOutput(output, buf, false)
+}
+
+func linkCommand(args []string) {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	format := flags.String("format", "", "machine code format: "+formatNames())
+	symFile := flags.String("sym", "", "write the symbol table to FILE")
+	ramFile := flags.String("ram", "", "write the initial RAM to FILE")
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		showUsage()
+	}
+
+	inputs, output := flags.Args()[:flags.NArg()-1], flags.Arg(flags.NArg()-1)
+
+	f, err := codeFormat(*format, output)
+
+	if err != nil {
+		fmt.Println(err)
+		showUsage()
+	}
+
+	var objects []*hack.Object
+
+	for _, input := range inputs {
+		file, err := os.Open(input)
+
+		if err != nil {
+			fmt.Printf("Can't open file for reading %s: %v", input, err)
+			showUsage()
+		}
+
+		obj, err := hack.ReadObject(input, file)
+		file.Close()
+
+		if err != nil {
+			printErrors(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	prog, err := hack.Link(objects...)
+
+	if err != nil {
+		printErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = f.Write(buf, prog.Code); err != nil {
+		fmt.Printf("Can't encode code: %v", err)
+		showUsage()
+	}
+
+	writeOutput(output, buf, false)
+	writeSymbolFile(*symFile, prog)
+	writeRAMFile(*ramFile, *format, prog)
+}
+
+func lintCommand(args []string) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	var includePath pathList
+	flags.Var(&includePath, "I", "search DIR for included files")
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		showUsage()
+	}
+
+	a := &hack.Assembler{IncludePath: includePath}
+	prog, err := a.AssembleFiles(flags.Args()...)
+
+	if err != nil {
+		printErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if warns := hack.Lint(prog); len(warns) > 0 {
+		printErrors(os.Stdout, warns)
+		os.Exit(1)
+	}
+}
+
+func fmtCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file")
+	check := flags.Bool("check", false, "list files which aren't formatted")
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || *write && *check {
+		showUsage()
+	}
+
+	unformatted := false
+
+	for _, path := range flags.Args() {
+		src, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			fmt.Printf("Can't read %s: %v", path, err)
+			showUsage()
+		}
+
+		out, err := hack.Format(path, bytes.NewReader(src))
+
+		if err != nil {
+			printErrors(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		switch {
+		case *check:
+			if !bytes.Equal(src, out) {
+				fmt.Println(path)
+				unformatted = true
+			}
+		case *write:
+			if !bytes.Equal(src, out) {
+				writeOutput(path, bytes.NewReader(out), true)
+			}
+		default:
+			writeOutput("", bytes.NewReader(out), false)
+		}
+	}
+
+	if unformatted {
+		os.Exit(1)
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			runCommand(os.Args[2:])
+			return
+		case "disasm":
+			disasmCommand(os.Args[2:])
+			return
+		case "debug":
+			debugCommand(os.Args[2:])
+			return
+		case "gdb":
+			gdbCommand(os.Args[2:])
+			return
+		case "dap":
+			if err := serveDAP(os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		case "vm":
+			vmCommand(os.Args[2:])
+			return
+		case "jack":
+			jackCommand(os.Args[2:])
+			return
+		case "link":
+			linkCommand(os.Args[2:])
+			return
+		case "lint":
+			lintCommand(os.Args[2:])
+			return
+		case "fmt":
+			fmtCommand(os.Args[2:])
+			return
+		case "lsp":
+			if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	listing := flag.String("l", "", "write a listing to FILE")
+	sourceMap := flag.String("map", "", "write a source map to FILE")
+	dotFile := flag.String("cfg", "", "write the control flow graph to FILE")
+	symFile := flag.String("sym", "", "write the symbol table to FILE")
+	importFile := flag.String("import", "", "read predefined symbols from FILE")
+	format := flag.String("format", "", "machine code format: "+formatNames())
+	ramFile := flag.String("ram", "", "write the initial RAM to FILE")
+	object := flag.Bool("c", false, "write a relocatable object")
+	optimize := flag.Bool("O", false, "optimize machine code")
+	var includePath pathList
+	flag.Var(&includePath, "I", "search DIR for included files")
+	flag.Usage = showUsage
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		showUsage()
+	}
+
+	inputs, output := flag.Args()[:flag.NArg()-1], flag.Arg(flag.NArg()-1)
+	a := &hack.Assembler{IncludePath: includePath, Optimize: *optimize}
+	var err error
+
+	if *importFile != "" {
+		if a.Symbols, err = loadSymbols(*importFile); err != nil {
+			printErrors(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *object {
+		if len(inputs) != 1 || *format != "" || *listing != "" || *sourceMap != "" || *dotFile != "" || *symFile != "" || *ramFile != "" {
+			showUsage()
+		}
+
+		compileObject(a, inputs[0], output)
+		return
+	}
+
+	f, err := codeFormat(*format, output)
+
+	if err != nil {
+		fmt.Println(err)
+		showUsage()
+	}
+
+	prog, err := a.AssembleFiles(inputs...)
+
+	if err != nil {
+		printErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *optimize {
+		printSavings(os.Stderr, output, prog)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = f.Write(buf, prog.Code); err != nil {
+		fmt.Printf("Can't encode code: %v", err)
+		showUsage()
+	}
+
+	writeOutput(output, buf, false)
+
+	if *listing != "" {
+		buf := &bytes.Buffer{}
+
+		for i, input := range inputs {
+			src, err := ioutil.ReadFile(input)
+
+			if err == nil && len(inputs) > 1 {
+				if i > 0 {
+					fmt.Fprintln(buf)
+				}
+				fmt.Fprintf(buf, "%s %s\n", hack.COMMENT, input)
+			}
+
+			if err == nil {
+				err = writeListing(buf, input, string(src), prog)
+			}
+
+			if err != nil {
+				fmt.Printf("Can't write listing: %v", err)
+				showUsage()
+			}
+		}
+
+		writeOutput(*listing, buf, false)
+	}
+
+	if *sourceMap != "" {
+		buf := &bytes.Buffer{}
+
+		if err = writeSourceMap(buf, prog); err != nil {
+			fmt.Printf("Can't write source map: %v", err)
+			showUsage()
+		}
+
+		writeOutput(*sourceMap, buf, false)
+	}
+
+	if *dotFile != "" {
+		buf := &bytes.Buffer{}
+
+		if err = writeDot(buf, inputs[0], prog); err != nil {
+			fmt.Printf("Can't write control flow graph: %v", err)
+			showUsage()
+		}
+
+		writeOutput(*dotFile, buf, false)
+	}
+
+	writeSymbolFile(*symFile, prog)
+	writeRAMFile(*ramFile, *format, prog)
+}
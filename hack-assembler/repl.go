@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const DEBUG_PROMPT = "(hdb) "
+
+const DEBUG_HELP = `step [N]          execute N instructions (s)
+next              execute the source line, stepping over calls (n)
+continue          run until a breakpoint, a watchpoint or halt (c)
+break ADDR        stop at a ROM address, label or FILE:LINE (b)
+watch ADDR        stop when a RAM word changes, by address or symbol (w)
+delete N          remove breakpoint or watchpoint N (d)
+info              list breakpoints and watchpoints (i)
+regs              print A, D and PC (r)
+print ADDR|REG    print a RAM word or a register (p)
+x ADDR [N]        print N RAM words from an address
+list              print the source around PC (l)
+reset             restart the program
+quit              leave the debugger (q)
+An empty line repeats the last step, next or continue.`
+
+// repl reads debugger commands from r and writes their output to w until
+// quit or the end of input
+func (d *debugger) repl(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	last := ""
+
+	fmt.Fprintln(w, d.location(d.cpu.PC))
+
+	for {
+		fmt.Fprint(w, DEBUG_PROMPT)
+
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			line = last
+		}
+
+		args := strings.Fields(line)
+
+		if len(args) == 0 {
+			continue
+		}
+
+		switch args[0] {
+		case "s", "step", "n", "next", "c", "continue":
+			last = line
+		case "q", "quit":
+			return
+		}
+
+		if err := d.command(w, args[0], args[1:]); err != nil {
+			fmt.Fprintln(w, err)
+		}
+	}
+}
+
+// command runs one debugger command
+func (d *debugger) command(w io.Writer, name string, args []string) error {
+	switch name {
+	case "s", "step":
+		n := 1
+
+		if len(args) > 0 {
+			var err error
+
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				return fmt.Errorf("bad step count \"%s\"", args[0])
+			}
+		}
+
+		d.report(w, d.step(n))
+
+	case "n", "next":
+		d.report(w, d.next())
+
+	case "c", "continue":
+		d.report(w, d.resume(func() bool { return false }))
+
+	case "b", "break", "w", "watch":
+		if len(args) != 1 {
+			return fmt.Errorf("expected %s ADDR", name)
+		}
+
+		watch := name == "w" || name == "watch"
+		parse := d.parseROMAddr
+
+		if watch {
+			parse = d.parseRAMAddr
+		}
+
+		addr, err := parse(args[0])
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, d.describe(d.breakAt(addr, watch)))
+
+	case "d", "delete":
+		if len(args) != 1 {
+			return fmt.Errorf("expected delete N")
+		}
+
+		if id, err := strconv.Atoi(args[0]); err != nil || !d.remove(id) {
+			return fmt.Errorf("no breakpoint or watchpoint %s", args[0])
+		}
+
+	case "i", "info":
+		if len(d.breakpoints) == 0 {
+			fmt.Fprintln(w, "No breakpoints or watchpoints")
+		}
+
+		for _, b := range d.breakpoints {
+			fmt.Fprintln(w, d.describe(b))
+		}
+
+	case "r", "regs":
+		fmt.Fprintln(w, d.register("A"))
+		fmt.Fprintln(w, d.register("D"))
+		fmt.Fprintln(w, d.register("PC"))
+
+	case "p", "print":
+		if len(args) != 1 {
+			return fmt.Errorf("expected print ADDR|REG")
+		}
+
+		if reg := strings.ToUpper(args[0]); reg == "A" || reg == "D" || reg == "PC" {
+			fmt.Fprintln(w, d.register(reg))
+			break
+		}
+
+		addr, err := d.parseRAMAddr(strings.TrimSuffix(strings.TrimPrefix(args[0], "RAM["), "]"))
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, d.ramString(addr))
+
+	case "x":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("expected x ADDR [N]")
+		}
+
+		addr, err := d.parseRAMAddr(args[0])
+		n := 1
+
+		if err == nil && len(args) == 2 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				err = fmt.Errorf("bad count \"%s\"", args[1])
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < n && int(addr)+i < RAM_SIZE; i++ {
+			fmt.Fprintln(w, d.ramString(addr+uint16(i)))
+		}
+
+	case "l", "list":
+		d.list(w)
+
+	case "reset":
+		d.reset()
+		fmt.Fprintln(w, d.location(d.cpu.PC))
+
+	case "h", "help":
+		fmt.Fprintln(w, DEBUG_HELP)
+
+	default:
+		return fmt.Errorf("unknown command \"%s\", try help", name)
+	}
+
+	return nil
+}
+
+// report prints why execution stopped and where
+func (d *debugger) report(w io.Writer, s stop) {
+	switch s.Reason {
+	case STOP_BREAKPOINT:
+		fmt.Fprintf(w, "Breakpoint %d\n", s.ID)
+	case STOP_WATCHPOINT:
+		fmt.Fprintf(w, "Watchpoint %d: %s changed from %d\n", s.ID, d.ramString(s.Addr), int16(s.Old))
+	case STOP_HALT:
+		fmt.Fprintf(w, "Halted after %d cycles\n", s.Cycles)
+	case STOP_LIMIT:
+		fmt.Fprintf(w, "Stopped after %d cycles\n", s.Cycles)
+	}
+
+	fmt.Fprintln(w, d.location(d.cpu.PC))
+}
+
+// describe formats a breakpoint or watchpoint
+func (d *debugger) describe(b breakpoint) string {
+	if b.Watch {
+		return fmt.Sprintf("Watchpoint %d: %s", b.ID, d.ramString(b.Addr))
+	}
+	return fmt.Sprintf("Breakpoint %d at %s", b.ID, d.location(b.Addr))
+}
+
+// register formats a register with its value
+func (d *debugger) register(reg string) string {
+	return fmt.Sprintf("%-2s = %s", reg, d.registerValue(reg))
+}
+
+// registerValue formats the value of a register, A with the name of the
+// RAM word it addresses and PC with its label
+func (d *debugger) registerValue(reg string) string {
+	switch reg {
+	case "A":
+		if name := d.ramName(d.cpu.A); name != "" {
+			return fmt.Sprintf("%d (%s)", int16(d.cpu.A), name)
+		}
+		return fmt.Sprintf("%d", int16(d.cpu.A))
+	case "D":
+		return fmt.Sprintf("%d", int16(d.cpu.D))
+	default:
+		if name := d.romName(d.cpu.PC); name != "" {
+			return fmt.Sprintf("%d <%s>", d.cpu.PC, name)
+		}
+		return fmt.Sprintf("%d", d.cpu.PC)
+	}
+}
+
+// list prints the source lines around the current one, or the
+// instructions around PC without a source
+func (d *debugger) list(w io.Writer) {
+	pc := d.cpu.PC
+
+	if line, ok := d.source[pc]; ok && len(d.fileLines(line.file)) > 0 {
+		lines := d.fileLines(line.file)
+
+		for num := line.num - 3; num <= line.num+3; num++ {
+			if num < 1 || num > len(lines) {
+				continue
+			}
+
+			mark := "  "
+
+			if num == line.num {
+				mark = "=>"
+			}
+
+			fmt.Fprintf(w, "%s %5d  %s\n", mark, num, lines[num-1])
+		}
+		return
+	}
+
+	for addr := int(pc) - 3; addr <= int(pc)+3; addr++ {
+		if addr < 0 || addr >= d.cpu.size {
+			continue
+		}
+
+		mark := "  "
+
+		if addr == int(pc) {
+			mark = "=>"
+		}
+
+		fmt.Fprintf(w, "%s %s\n", mark, d.location(uint16(addr)))
+	}
+}
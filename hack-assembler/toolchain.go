@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+	"github.com/mluts/learning-go/hack-assembler/jack"
+	"github.com/mluts/learning-go/hack-assembler/vm"
+)
+
+// vmSource is VM code together with the file name its statics are
+// named after
+type vmSource struct {
+	name string
+	code []byte
+}
+
+// sourceFiles expands directories in paths to the files with given
+// extensions they contain
+func sourceFiles(paths []string, exts ...string) (files []string, err error) {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		for _, ext := range exts {
+			matches, err := filepath.Glob(filepath.Join(path, "*"+ext))
+
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, matches...)
+		}
+	}
+
+	return
+}
+
+// dropCompiled removes .vm files which are compiled from .jack files
+// present in files
+func dropCompiled(files []string) (result []string) {
+	jackFiles := map[string]bool{}
+
+	for _, path := range files {
+		if strings.HasSuffix(path, ".jack") {
+			jackFiles[strings.TrimSuffix(path, ".jack")] = true
+		}
+	}
+
+	for _, path := range files {
+		if !strings.HasSuffix(path, ".vm") || !jackFiles[strings.TrimSuffix(path, ".vm")] {
+			result = append(result, path)
+		}
+	}
+
+	return
+}
+
+// translateVM translates VM sources into a single assembly program
+func translateVM(sources []vmSource, bootstrap bool) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	t := vm.New(buf)
+
+	if bootstrap {
+		if err := t.Bootstrap(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, src := range sources {
+		if err := t.Translate(src.name, bytes.NewReader(src.code)); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+// compileJack compiles .jack files and reads .vm files into VM sources.
+// Errors of all classes are collected into one hack.ErrorList.
+func compileJack(files []string) (sources []vmSource, err error) {
+	var errs hack.ErrorList
+
+	for _, path := range files {
+		src, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasSuffix(path, ".jack") {
+			sources = append(sources, vmSource{path, src})
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		err = jack.Compile(path, bytes.NewReader(src), buf)
+
+		if list, ok := err.(hack.ErrorList); ok {
+			errs = append(errs, list...)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, vmSource{strings.TrimSuffix(path, ".jack") + ".vm", buf.Bytes()})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return sources, nil
+}
+
+// assembleOutput assembles asm when a format is given or output has
+// a machine code extension, optionally optimizing it
+func assembleOutput(output, format string, optimize bool, asm io.Reader) (io.Reader, error) {
+	if _, ok := findFormat("", output); format == "" && !ok {
+		return asm, nil
+	}
+
+	f, err := codeFormat(format, output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a := hack.New(strings.TrimSuffix(output, filepath.Ext(output)) + ".asm")
+	a.Optimize = optimize
+	prog, err := a.AssembleProgram(asm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if optimize {
+		printSavings(os.Stderr, output, prog)
+	}
+
+	buf := &bytes.Buffer{}
+
+	if err = f.Write(buf, prog.Code); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// writeOutput copies r to a new file at path, or to stdout when path
+// is empty. Existing files are replaced only when overwrite is set.
+func writeOutput(path string, r io.Reader, overwrite bool) {
+	var w io.Writer = os.Stdout
+
+	if path != "" {
+		mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+
+		if overwrite {
+			mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+
+		outputFile, err := os.OpenFile(path, mode, 0666)
+
+		if err != nil {
+			fmt.Printf("Can't open file for writing %s: %v", path, err)
+			showUsage()
+		}
+		defer outputFile.Close()
+
+		w = outputFile
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		fmt.Printf("Can't write output: %v", err)
+		showUsage()
+	}
+}
+
+// printErrors prints every error of an ErrorList on its own line
+func printErrors(w io.Writer, err error) {
+	if list, ok := err.(hack.ErrorList); ok {
+		for _, e := range list {
+			fmt.Fprintln(w, e)
+		}
+	} else {
+		fmt.Fprintln(w, err)
+	}
+}
+
+// printSavings prints how many words the optimizer saved for every
+// reason
+func printSavings(w io.Writer, name string, prog *hack.Program) {
+	counts := map[string]int{}
+	var reasons []string
+
+	for _, o := range prog.Optimizations {
+		if counts[o.Reason] == 0 {
+			reasons = append(reasons, o.Reason)
+		}
+		counts[o.Reason]++
+	}
+
+	fmt.Fprintf(w, "%s: optimizer saved %d words", name, len(prog.Optimizations))
+
+	for i, reason := range reasons {
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+
+		fmt.Fprintf(w, "%s%d %s", sep, counts[reason], reason)
+	}
+
+	fmt.Fprintln(w)
+}
+
+func vmCommand(args []string) {
+	flags := flag.NewFlagSet("vm", flag.ExitOnError)
+	bootstrap := flags.Bool("bootstrap", false, "emit code calling Sys.init")
+	output := flags.String("o", "", "output file, .hack for machine code")
+	format := flags.String("format", "", "machine code format")
+	optimize := flags.Bool("O", false, "optimize machine code")
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		showUsage()
+	}
+
+	files, err := sourceFiles(flags.Args(), ".vm")
+
+	if err != nil {
+		fmt.Printf("Can't read input: %v", err)
+		showUsage()
+	}
+
+	var sources []vmSource
+
+	for _, path := range files {
+		src, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			fmt.Printf("Can't read input: %v", err)
+			showUsage()
+		}
+
+		sources = append(sources, vmSource{path, src})
+	}
+
+	asm, err := translateVM(sources, *bootstrap)
+
+	if err == nil {
+		var out io.Reader
+
+		if out, err = assembleOutput(*output, *format, *optimize, asm); err == nil {
+			writeOutput(*output, out, false)
+			return
+		}
+	}
+
+	printErrors(os.Stderr, err)
+	os.Exit(1)
+}
+
+func jackCommand(args []string) {
+	flags := flag.NewFlagSet("jack", flag.ExitOnError)
+	output := flags.String("o", "", "output file, .asm or .hack")
+	format := flags.String("format", "", "machine code format")
+	optimize := flags.Bool("O", false, "optimize machine code")
+	flags.Usage = showUsage
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		showUsage()
+	}
+
+	files, err := sourceFiles(flags.Args(), ".jack", ".vm")
+
+	if err != nil {
+		fmt.Printf("Can't read input: %v", err)
+		showUsage()
+	}
+
+	files = dropCompiled(files)
+
+	sources, err := compileJack(files)
+
+	if err != nil {
+		printErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		for i, path := range files {
+			if strings.HasSuffix(path, ".jack") {
+				writeOutput(sources[i].name, bytes.NewReader(sources[i].code), true)
+			}
+		}
+		return
+	}
+
+	asm, err := translateVM(sources, true)
+
+	if err == nil {
+		var out io.Reader
+
+		if out, err = assembleOutput(*output, *format, *optimize, asm); err == nil {
+			writeOutput(*output, out, false)
+			return
+		}
+	}
+
+	printErrors(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,451 @@
+// Package vm translates nand2tetris stack VM code into Hack assembly
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+const (
+	COMMENT = "//"
+
+	STACK_BASE = 256
+	POINTER    = 3
+	TEMP       = 5
+	TEMP_SIZE  = 8
+
+	SYS_INIT = "Sys.init"
+)
+
+var segmentSymbols = map[string]string{
+	"local":    "LCL",
+	"argument": "ARG",
+	"this":     "THIS",
+	"that":     "THAT",
+}
+
+var binaryOps = map[string]string{
+	"add": "M=D+M",
+	"sub": "M=M-D",
+	"and": "M=D&M",
+	"or":  "M=D|M",
+}
+
+var unaryOps = map[string]string{
+	"neg": "M=-M",
+	"not": "M=!M",
+}
+
+var compareJumps = map[string]string{
+	"eq": "JEQ",
+	"gt": "JGT",
+	"lt": "JLT",
+}
+
+// Translator writes Hack assembly for VM files to w
+type Translator struct {
+	w   *bufio.Writer
+	err error
+
+	file     string
+	function string
+	labels   int
+}
+
+func New(w io.Writer) *Translator {
+	return &Translator{w: bufio.NewWriter(w)}
+}
+
+func (t *Translator) emit(lines ...string) {
+	for _, line := range lines {
+		if t.err != nil {
+			return
+		}
+		_, t.err = t.w.WriteString(line + "\n")
+	}
+}
+
+func (t *Translator) newLabel(prefix string) string {
+	t.labels++
+	return fmt.Sprintf("%s.%d", prefix, t.labels)
+}
+
+// Bootstrap emits code which sets SP to 256 and calls Sys.init
+func (t *Translator) Bootstrap() error {
+	t.emit(
+		COMMENT+" bootstrap",
+		fmt.Sprintf("@%d", STACK_BASE),
+		"D=A",
+		"@SP",
+		"M=D",
+	)
+	t.call(SYS_INIT, 0)
+
+	return t.Flush()
+}
+
+// Flush writes buffered assembly to the underlying writer
+func (t *Translator) Flush() error {
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+// Translate translates VM code read from r. Static variables are named
+// after the base name of file.
+func (t *Translator) Translate(file string, r io.Reader) error {
+	var errs hack.ErrorList
+
+	t.file = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	t.function = ""
+
+	scanner := bufio.NewScanner(r)
+
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.Split(scanner.Text(), COMMENT)[0]
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		t.emit(COMMENT + " " + strings.Join(fields, " "))
+
+		if err := t.command(fields); err != nil {
+			errs = append(errs, &hack.Error{File: file, Line: num, Msg: err.Error()})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := t.Flush(); err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func arity(fields []string, n int) error {
+	if len(fields) != n+1 {
+		return fmt.Errorf("%s expects %d arguments, have %d", fields[0], n, len(fields)-1)
+	}
+	return nil
+}
+
+func parseIndex(str string) (int, error) {
+	i, err := strconv.ParseUint(str, 10, 15)
+
+	if err != nil {
+		return 0, fmt.Errorf("bad index \"%s\"", str)
+	}
+
+	return int(i), nil
+}
+
+func isName(str string) bool {
+	if len(str) == 0 || (str[0] >= '0' && str[0] <= '9') {
+		return false
+	}
+
+	for _, ch := range str {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case strings.ContainsRune("_.:", ch):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t *Translator) command(fields []string) (err error) {
+	cmd := fields[0]
+
+	switch cmd {
+	case "push", "pop":
+		if err = arity(fields, 2); err != nil {
+			return
+		}
+
+		var i int
+
+		if i, err = parseIndex(fields[2]); err != nil {
+			return
+		}
+
+		if cmd == "push" {
+			return t.push(fields[1], i)
+		}
+		return t.pop(fields[1], i)
+
+	case "label", "goto", "if-goto":
+		if err = arity(fields, 1); err != nil {
+			return
+		}
+
+		if !isName(fields[1]) {
+			return fmt.Errorf("bad label \"%s\"", fields[1])
+		}
+
+		t.branch(cmd, fields[1])
+
+	case "function", "call":
+		if err = arity(fields, 2); err != nil {
+			return
+		}
+
+		if !isName(fields[1]) {
+			return fmt.Errorf("bad function name \"%s\"", fields[1])
+		}
+
+		var n int
+
+		if n, err = parseIndex(fields[2]); err != nil {
+			return
+		}
+
+		if cmd == "function" {
+			t.declare(fields[1], n)
+		} else {
+			t.call(fields[1], n)
+		}
+
+	case "return":
+		if err = arity(fields, 0); err != nil {
+			return
+		}
+
+		t.ret()
+
+	default:
+		if !isArithmetic(cmd) {
+			return fmt.Errorf("unknown command \"%s\"", cmd)
+		}
+
+		if err = arity(fields, 0); err != nil {
+			return
+		}
+
+		t.arithmetic(cmd)
+	}
+
+	return
+}
+
+func isArithmetic(cmd string) bool {
+	for _, ops := range []map[string]string{binaryOps, unaryOps, compareJumps} {
+		if _, ok := ops[cmd]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Translator) pushD() {
+	t.emit("@SP", "A=M", "M=D", "@SP", "M=M+1")
+}
+
+func (t *Translator) popD() {
+	t.emit("@SP", "AM=M-1", "D=M")
+}
+
+// fixedAddr returns the symbol or address of segments that don't depend
+// on a base pointer
+func (t *Translator) fixedAddr(segment string, i int) (string, error) {
+	switch segment {
+	case "pointer":
+		if i > 1 {
+			return "", fmt.Errorf("pointer index %d out of range 0..1", i)
+		}
+		return strconv.Itoa(POINTER + i), nil
+	case "temp":
+		if i >= TEMP_SIZE {
+			return "", fmt.Errorf("temp index %d out of range 0..%d", i, TEMP_SIZE-1)
+		}
+		return strconv.Itoa(TEMP + i), nil
+	case "static":
+		return fmt.Sprintf("%s.%d", t.file, i), nil
+	}
+
+	return "", fmt.Errorf("unknown segment \"%s\"", segment)
+}
+
+func (t *Translator) push(segment string, i int) error {
+	if segment == "constant" {
+		t.emit(fmt.Sprintf("@%d", i), "D=A")
+		t.pushD()
+		return nil
+	}
+
+	if base, ok := segmentSymbols[segment]; ok {
+		t.emit(fmt.Sprintf("@%d", i), "D=A", "@"+base, "A=D+M", "D=M")
+		t.pushD()
+		return nil
+	}
+
+	addr, err := t.fixedAddr(segment, i)
+
+	if err != nil {
+		return err
+	}
+
+	t.emit("@"+addr, "D=M")
+	t.pushD()
+	return nil
+}
+
+func (t *Translator) pop(segment string, i int) error {
+	if segment == "constant" {
+		return fmt.Errorf("can't pop to constant")
+	}
+
+	if base, ok := segmentSymbols[segment]; ok {
+		t.emit(fmt.Sprintf("@%d", i), "D=A", "@"+base, "D=D+M", "@R13", "M=D")
+		t.popD()
+		t.emit("@R13", "A=M", "M=D")
+		return nil
+	}
+
+	addr, err := t.fixedAddr(segment, i)
+
+	if err != nil {
+		return err
+	}
+
+	t.popD()
+	t.emit("@"+addr, "M=D")
+	return nil
+}
+
+func (t *Translator) arithmetic(cmd string) {
+	if comp, ok := binaryOps[cmd]; ok {
+		t.popD()
+		t.emit("A=A-1", comp)
+		return
+	}
+
+	if comp, ok := unaryOps[cmd]; ok {
+		t.emit("@SP", "A=M-1", comp)
+		return
+	}
+
+	if jmp, ok := compareJumps[cmd]; ok {
+		label := t.newLabel("$" + cmd)
+
+		t.popD()
+		t.emit(
+			"A=A-1",
+			"D=M-D",
+			"M=-1",
+			"@"+label,
+			"D;"+jmp,
+			"@SP",
+			"A=M-1",
+			"M=0",
+			"("+label+")",
+		)
+	}
+}
+
+// scope returns the label name local to the current function
+func (t *Translator) scope(label string) string {
+	if t.function == "" {
+		return t.file + "$" + label
+	}
+	return t.function + "$" + label
+}
+
+func (t *Translator) branch(cmd, label string) {
+	label = t.scope(label)
+
+	switch cmd {
+	case "label":
+		t.emit("(" + label + ")")
+	case "goto":
+		t.emit("@"+label, "0;JMP")
+	case "if-goto":
+		t.popD()
+		t.emit("@"+label, "D;JNE")
+	}
+}
+
+func (t *Translator) declare(function string, locals int) {
+	t.function = function
+	t.emit("(" + function + ")")
+
+	for i := 0; i < locals; i++ {
+		t.emit("@SP", "A=M", "M=0", "@SP", "M=M+1")
+	}
+}
+
+func (t *Translator) call(function string, args int) {
+	ret := t.newLabel(function + "$ret")
+
+	t.emit("@"+ret, "D=A")
+	t.pushD()
+
+	for _, symbol := range []string{"LCL", "ARG", "THIS", "THAT"} {
+		t.emit("@"+symbol, "D=M")
+		t.pushD()
+	}
+
+	t.emit(
+		"@SP",
+		"D=M",
+		fmt.Sprintf("@%d", args+5),
+		"D=D-A",
+		"@ARG",
+		"M=D",
+		"@SP",
+		"D=M",
+		"@LCL",
+		"M=D",
+		"@"+function,
+		"0;JMP",
+		"("+ret+")",
+	)
+}
+
+func (t *Translator) ret() {
+	t.emit(
+		"@LCL",
+		"D=M",
+		"@R13",
+		"M=D",
+		"@5",
+		"A=D-A",
+		"D=M",
+		"@R14",
+		"M=D",
+	)
+	t.popD()
+	t.emit(
+		"@ARG",
+		"A=M",
+		"M=D",
+		"@ARG",
+		"D=M+1",
+		"@SP",
+		"M=D",
+	)
+
+	for _, symbol := range []string{"THAT", "THIS", "ARG", "LCL"} {
+		t.emit("@R13", "AM=M-1", "D=M", "@"+symbol, "M=D")
+	}
+
+	t.emit("@R14", "A=M", "0;JMP")
+}
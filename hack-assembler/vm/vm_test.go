@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+)
+
+func translate(t *testing.T, file, src string) string {
+	buf := &bytes.Buffer{}
+
+	if err := New(buf).Translate(file, strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestStaticNaming(t *testing.T) {
+	asm := translate(t, "dir/Foo.vm", "push static 3\npop static 1")
+
+	if !strings.Contains(asm, "@Foo.3\n") || !strings.Contains(asm, "@Foo.1\n") {
+		t.Errorf("Statics should be named after the file:\n%s", asm)
+	}
+}
+
+func TestLabelScope(t *testing.T) {
+	asm := translate(t, "Foo.vm", "function Foo.bar 0\nlabel LOOP\ngoto LOOP")
+
+	if !strings.Contains(asm, "(Foo.bar$LOOP)\n") || !strings.Contains(asm, "@Foo.bar$LOOP\n") {
+		t.Errorf("Labels should be scoped to the function:\n%s", asm)
+	}
+}
+
+func TestComments(t *testing.T) {
+	asm := translate(t, "Foo.vm", "push   constant 7 // seven")
+
+	if !strings.HasPrefix(asm, "// push constant 7\n") {
+		t.Errorf("Each command should be preceded by a comment:\n%s", asm)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	src := `push constant 1
+pop constant 1
+push nowhere 1
+push temp 8
+pop pointer 2
+add 1
+frobnicate
+push local x
+label 1abc
+call Foo`
+
+	err := New(&bytes.Buffer{}).Translate("Foo.vm", strings.NewReader(src))
+
+	errs, ok := err.(hack.ErrorList)
+
+	if !ok {
+		t.Fatalf("Expected hack.ErrorList, have %v", err)
+	}
+
+	expected := []string{
+		"Foo.vm:2: can't pop to constant",
+		"Foo.vm:3: unknown segment \"nowhere\"",
+		"Foo.vm:4: temp index 8 out of range 0..7",
+		"Foo.vm:5: pointer index 2 out of range 0..1",
+		"Foo.vm:6: add expects 0 arguments, have 1",
+		"Foo.vm:7: unknown command \"frobnicate\"",
+		"Foo.vm:8: bad index \"x\"",
+		"Foo.vm:9: bad label \"1abc\"",
+		"Foo.vm:10: call expects 2 arguments, have 1",
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, have %d: %v", len(expected), len(errs), errs)
+	}
+
+	for i, e := range errs {
+		if e.Error() != expected[i] {
+			t.Errorf("Expected \"%s\", have \"%s\"", expected[i], e)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mluts/learning-go/hack-assembler/hack"
+	"github.com/mluts/learning-go/hack-assembler/vm"
+)
+
+// runVM translates VM files given as name/source pairs and runs them
+func runVM(t *testing.T, bootstrap bool, files ...string) *CPU {
+	buf := &bytes.Buffer{}
+	tr := vm.New(buf)
+
+	if bootstrap {
+		if err := tr.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		buf.WriteString("@256\nD=A\n@SP\nM=D\n")
+	}
+
+	for i := 0; i < len(files); i += 2 {
+		if err := tr.Translate(files[i], strings.NewReader(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cpu := newCPU(mustCompile(t, buf.String()))
+	cpu.run(100000)
+
+	return cpu
+}
+
+func TestVMArithmetic(t *testing.T) {
+	cpu := runVM(t, false, "Test.vm", `
+		push constant 17
+		push constant 17
+		eq
+		push constant 892
+		push constant 891
+		lt
+		push constant 32767
+		push constant 32766
+		gt
+		push constant 57
+		push constant 31
+		push constant 53
+		add
+		push constant 112
+		sub
+		neg
+		and
+		push constant 82
+		or
+		not
+	`)
+
+	expected := []int16{-1, 0, -1, -91}
+
+	if cpu.RAM[0] != 260 {
+		t.Fatalf("SP should eq 260, have %d", cpu.RAM[0])
+	}
+
+	for i, v := range expected {
+		if int16(cpu.RAM[256+i]) != v {
+			t.Errorf("RAM[%d] should eq %d, have %d", 256+i, v, int16(cpu.RAM[256+i]))
+		}
+	}
+}
+
+func TestVMSegments(t *testing.T) {
+	cpu := runVM(t, false, "Test.vm", `
+		push constant 3030
+		pop pointer 0
+		push constant 3040
+		pop pointer 1
+		push constant 32
+		pop this 2
+		push constant 46
+		pop that 6
+		push pointer 0
+		push pointer 1
+		add
+		push this 2
+		sub
+		push that 6
+		add
+		pop temp 6
+		push constant 7
+		pop static 2
+		push static 2
+		push temp 6
+		add
+	`)
+
+	switch {
+	case cpu.RAM[3032] != 32:
+		t.Errorf("this 2 should eq 32, have %d", cpu.RAM[3032])
+	case cpu.RAM[3046] != 46:
+		t.Errorf("that 6 should eq 46, have %d", cpu.RAM[3046])
+	case cpu.RAM[11] != 6084:
+		t.Errorf("temp 6 should eq 6084, have %d", cpu.RAM[11])
+	case cpu.RAM[256] != 6091:
+		t.Errorf("Stack top should eq 6091, have %d", cpu.RAM[256])
+	}
+}
+
+func TestVMFunctions(t *testing.T) {
+	cpu := runVM(t, true,
+		"Main.vm", `
+		function Main.fibonacci 0
+		push argument 0
+		push constant 2
+		lt
+		if-goto BASE
+		push argument 0
+		push constant 2
+		sub
+		call Main.fibonacci 1
+		push argument 0
+		push constant 1
+		sub
+		call Main.fibonacci 1
+		add
+		return
+		label BASE
+		push argument 0
+		return
+		`,
+		"Sys.vm", `
+		function Sys.init 1
+		push constant 10
+		call Main.fibonacci 1
+		pop static 0
+		push static 0
+		pop local 0
+		label END
+		goto END
+		`)
+
+	if cpu.RAM[16] != 55 {
+		t.Errorf("fibonacci(10) should eq 55, have %d", cpu.RAM[16])
+	}
+
+	if !cpu.halted {
+		t.Error("Program should halt")
+	}
+}
+
+func TestVMOptimized(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tr := vm.New(buf)
+
+	if err := tr.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	src := "function Sys.init 0\npush constant 7\npush constant 8\nadd\npop static 0\nlabel END\ngoto END\n"
+
+	if err := tr.Translate("Sys.vm", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+
+	a := hack.New("Sys.asm")
+	a.Optimize = true
+	prog, err := a.AssembleProgram(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prog.Optimizations) == 0 {
+		t.Error("Expected optimizations of translated code")
+	}
+
+	cpu := newCPU(prog.Code)
+	cpu.run(100000)
+
+	if cpu.RAM[16] != 15 || !cpu.halted {
+		t.Errorf("static 0 should eq 15 and the program should halt, have %d", cpu.RAM[16])
+	}
+}